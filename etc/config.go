@@ -4,24 +4,154 @@ import (
 	"github.com/spf13/viper"
 	"os"
 	"path"
+	"sync/atomic"
 )
 
 // auto generate struct
 // https://mholt.github.io/json-to-go/
 // use mapstructure to replace json for '_' key words, e.g. rpc_port,big_data
 type ConfigStruct struct {
-	Source       string `json:"source"`
-	Output       string `json:"output"`
-	Interval     int    `json:"interval"`
-	Since        string `json:"since"`
-	HoldDays     int    `mapstructure:"hold_days"`
-	MaxWorker    int    `mapstructure:"max_worker"`
-	CopyWaitTime int    `mapstructure:"copy_wait_time"`
-	Log          struct {
+	Source          string `json:"source"`
+	Output          string `json:"output"`
+	Interval        int    `json:"interval"`
+	Since           string `json:"since"`
+	HoldDays        int    `mapstructure:"hold_days"`
+	MaxWorker       int    `mapstructure:"max_worker"`
+	CopyWaitTime    int    `mapstructure:"copy_wait_time"`
+	ConflictPolicy  string `mapstructure:"conflict_policy"`
+	CopyRetries     int    `mapstructure:"copy_retries"`
+	CopyRetryDelay  int    `mapstructure:"copy_retry_delay"`
+	CopyTimeout     int    `mapstructure:"copy_timeout"`
+	ExamTimeout     int    `mapstructure:"exam_timeout"`
+	TaskTimeout     int    `mapstructure:"task_timeout"`
+	DeadLetterAfter int    `mapstructure:"dead_letter_after"`
+	StateDir        string `mapstructure:"state_dir"`
+	Scan            struct {
+		MaxDepth        int      `mapstructure:"max_depth"`
+		ExcludeDirs     []string `mapstructure:"exclude_dirs"`
+		DateDirPrefix   string   `mapstructure:"date_dir_prefix"`
+		PruneByDirMtime bool     `mapstructure:"prune_by_dir_mtime"`
+	} `json:"scan"`
+	Queue struct {
+		Order        []string `json:"order"`
+		PriorityTags []struct {
+			Element string `json:"element"`
+			Value   string `json:"value"`
+			Weight  int    `json:"weight"`
+		} `mapstructure:"priority_tags"`
+		StarvationAfter int `mapstructure:"starvation_after"`
+	} `json:"queue"`
+	PostCopy struct {
+		Action      string `json:"action"`
+		ArchiveDir  string `mapstructure:"archive_dir"`
+		DeleteDelay int    `mapstructure:"delete_delay"`
+	} `mapstructure:"post_copy"`
+	Compress struct {
+		Mode       string   `json:"mode"`
+		Level      int      `json:"level"`
+		Extensions []string `json:"extensions"`
+	} `json:"compress"`
+	Encrypt struct {
+		Enabled bool   `json:"enabled"`
+		KeyFile string `mapstructure:"key_file"`
+		KeyEnv  string `mapstructure:"key_env"`
+	} `json:"encrypt"`
+	Dedup struct {
+		Enabled    bool     `json:"enabled"`
+		Extensions []string `json:"extensions"`
+	} `json:"dedup"`
+	Research struct {
+		Enabled    bool     `json:"enabled"`
+		Output     string   `json:"output"`
+		Extensions []string `json:"extensions"`
+		Prefix     string   `json:"prefix"`
+		Profile    []struct {
+			Element string `json:"element"`
+			Action  string `json:"action"`
+			Value   string `json:"value"`
+		} `json:"profile"`
+	} `json:"research"`
+	Disk struct {
+		ReserveMB      int  `mapstructure:"reserve_mb"`
+		EmergencyClean bool `mapstructure:"emergency_clean"`
+	} `json:"disk"`
+	Verify struct {
+		Interval int  `json:"interval"`
+		Hash     bool `json:"hash"`
+	} `json:"verify"`
+	Pause struct {
+		Windows []struct {
+			Start    string `json:"start"`
+			End      string `json:"end"`
+			Weekdays []int  `json:"weekdays"`
+		} `json:"windows"`
+		SourceActive int `mapstructure:"source_active"`
+	} `json:"pause"`
+	Audit struct {
 		Path string `json:"path"`
+	} `json:"audit"`
+	Hooks []struct {
+		Name       string   `json:"name"`
+		Type       string   `json:"type"`
+		Path       string   `json:"path"`
+		Args       []string `json:"args"`
+		Url        string   `json:"url"`
+		Dir        string   `json:"dir"`
+		Timeout    int      `json:"timeout"`
+		Retries    int      `json:"retries"`
+		RetryDelay int      `mapstructure:"retry_delay"`
+	} `json:"hooks"`
+	Stream struct {
+		Buffer           int `json:"buffer"`
+		ProgressInterval int `mapstructure:"progress_interval_ms"`
+	} `json:"stream"`
+	Notify struct {
+		Events         []string `json:"events"`
+		Timeout        int      `json:"timeout"`
+		CleanThreshold int      `mapstructure:"clean_threshold"`
+		Webhook        struct {
+			Url string `json:"url"`
+		} `json:"webhook"`
+		Smtp struct {
+			Address  string   `json:"address"`
+			Username string   `json:"username"`
+			Password string   `json:"password"`
+			From     string   `json:"from"`
+			To       []string `json:"to"`
+		} `json:"smtp"`
+		Syslog struct {
+			Enabled bool   `json:"enabled"`
+			Network string `json:"network"`
+			Address string `json:"address"`
+			Tag     string `json:"tag"`
+		} `json:"syslog"`
+		Command struct {
+			Path string   `json:"path"`
+			Args []string `json:"args"`
+		} `json:"command"`
+	} `json:"notify"`
+	Log struct {
+		Path    string            `json:"path"`
+		Lang    string            `json:"lang"`
+		Level   string            `json:"level"`
+		Modules map[string]string `json:"modules"`
+		Encoder string            `json:"encoder"`
+		Outputs []string          `json:"outputs"`
+		Rotate  struct {
+			MaxSize    int  `mapstructure:"max_size"`
+			MaxBackups int  `mapstructure:"max_backups"`
+			MaxAge     int  `mapstructure:"max_age"`
+			Compress   bool `json:"compress"`
+		} `json:"rotate"`
+		Syslog struct {
+			Network string `json:"network"`
+			Address string `json:"address"`
+			Tag     string `json:"tag"`
+		} `json:"syslog"`
 		Host struct {
 			Address string `json:"address"`
 			Port    int    `json:"port"`
+			Token   string `json:"token"`
 		} `json:"host"`
 	} `json:"log"`
 }
@@ -29,10 +159,11 @@ type ConfigStruct struct {
 var (
 	defaultFilePath = "/etc/config.json"
 	ViperConfig     *viper.Viper
-	Config          *ConfigStruct
-	serverPath      = os.Getenv("DCM_TIMER_PATH")
-	serverType      = os.Getenv("DCM_TIMER_TYPE")
-	serverTypeProd  = "production"
+	// 当前生效的*ConfigStruct, 重新加载时整体替换, 通过Get读取
+	config         atomic.Value
+	serverPath     = os.Getenv("DCM_TIMER_PATH")
+	serverType     = os.Getenv("DCM_TIMER_TYPE")
+	serverTypeProd = "production"
 )
 
 func init() {
@@ -55,22 +186,59 @@ func InitConfig(filePath string) {
 			panic(err)
 		}
 	}
-	err = ViperConfig.Unmarshal(&Config)
+	var c *ConfigStruct
+	err = ViperConfig.Unmarshal(&c)
 	if err != nil {
 		panic(err)
 	}
+	Set(c)
+}
+
+// 当前生效的配置, 调用方不要修改返回的结构
+func Get() *ConfigStruct {
+	return config.Load().(*ConfigStruct)
+}
+
+// 整体替换生效的配置, 正在运行的任务继续使用已经读到的配置
+func Set(c *ConfigStruct) {
+	config.Store(c)
+}
+
+// 重新读取配置文件, 失败时保留原来的配置
+func Reload() error {
+	v := viper.New()
+	v.SetConfigFile(ViperConfig.ConfigFileUsed())
+	if err := v.ReadInConfig(); err != nil {
+		return err
+	}
+	var c *ConfigStruct
+	if err := v.Unmarshal(&c); err != nil {
+		return err
+	}
+	ViperConfig = v
+	Set(c)
+	return nil
 }
+
 func GetServerDir() string {
 	//return GetViperConfig().GetString("server.dir")
 	return serverPath
 }
 
 func GetDstPath() string {
-	return path.Join(Config.Output)
+	return path.Join(Get().Output)
 }
 
 func GetSrcPath() string {
-	return path.Join(Config.Source)
+	return path.Join(Get().Source)
+}
+
+// 归档目录, 相对路径时以源目录为根
+func GetArchivePath() string {
+	if Get().PostCopy.ArchiveDir == "" || path.IsAbs(Get().PostCopy.ArchiveDir) {
+		return path.Clean(Get().PostCopy.ArchiveDir)
+	}
+	return path.Join(Get().Source, Get().PostCopy.ArchiveDir)
 }
 
 func ServerTypeIsProd() bool {
@@ -80,14 +248,35 @@ func ServerTypeIsProd() bool {
 	return false
 }
 
+// 运行状态目录, 存放核对报告等
+func GetStatePath() string {
+	return path.Join(GetServerDir(), Get().StateDir)
+}
+
+func GetResearchPath() string {
+	return path.Join(Get().Research.Output)
+}
+
+// 密钥文件, 相对路径相对于程序目录, 没有配置时为空
+func GetKeyFilePath() string {
+	if Get().Encrypt.KeyFile == "" || path.IsAbs(Get().Encrypt.KeyFile) {
+		return Get().Encrypt.KeyFile
+	}
+	return path.Join(GetServerDir(), Get().Encrypt.KeyFile)
+}
+
 func GetLogPath() string {
-	return path.Join(GetServerDir(), Config.Log.Path)
+	return path.Join(GetServerDir(), Get().Log.Path)
+}
+
+func GetLogLang() string {
+	return Get().Log.Lang
 }
 
 func GetLogHostAddress() string {
-	return Config.Log.Host.Address
+	return Get().Log.Host.Address
 }
 
 func GetLogHostPort() int {
-	return Config.Log.Host.Port
+	return Get().Log.Host.Port
 }
@@ -1,12 +1,102 @@
 package main
 
 import (
-	_ "github.com/CodyGuo/godaemon"
-	_ "github.com/sanguohot/dcm-timer/pkg/core"
+	"flag"
+	"fmt"
+	"github.com/sanguohot/dcm-timer/etc"
+	"github.com/sanguohot/dcm-timer/pkg/cmd"
+	"github.com/sanguohot/dcm-timer/pkg/common/log"
+	"github.com/sanguohot/dcm-timer/pkg/core"
+	"github.com/sanguohot/dcm-timer/pkg/daemon"
+	"github.com/sanguohot/dcm-timer/pkg/hook"
+	"github.com/sanguohot/dcm-timer/pkg/notify"
+	"github.com/sanguohot/dcm-timer/pkg/server"
+	"go.uber.org/zap"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var (
+	daemonize = flag.Bool("d", false, "以守护进程方式在后台运行, 由systemd管理时不需要")
 )
 
 func main() {
+	flag.Parse()
+	args := flag.Args()
+	// 兼容旧的"-d true"写法, 布尔参数不会消费后面的值
+	if len(args) > 0 && (args[0] == "true" || args[0] == "false") {
+		*daemonize = args[0] == "true"
+		args = args[1:]
+	}
+	if len(args) > 0 {
+		code, ok := cmd.Run(args[0], args[1:])
+		if !ok {
+			// 拼错的子命令不能当作启动服务, 否则会再启动一个实例
+			fmt.Fprintf(os.Stderr, "未知的子命令: %s\n\n", args[0])
+			cmd.PrintUsage()
+			os.Exit(2)
+		}
+		os.Exit(code)
+	}
+	if *daemonize {
+		pid, err := daemon.Start(args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("[PID] %d running...\n", pid)
+		os.Exit(0)
+	}
+	if err := core.AcquireInstanceLock(); err != nil {
+		log.Fatal("instance.lock_failed", zap.Error(err))
+	}
+	core.Start()
+	server.Start()
+	log.Info("daemon.start", zap.Int("pid", os.Getpid()))
+	notify.Send(notify.EventDaemonStart, log.Msg("daemon.start"), map[string]interface{}{"pid": os.Getpid()})
+	sdNotify(daemon.StateReady)
+	stopWatchdog := make(chan struct{})
+	go daemon.Watchdog(core.Alive, stopWatchdog)
 	done := make(chan os.Signal, 1)
-	<-done
+	signal.Notify(done, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	sig := <-done
+	// SIGHUP重新加载配置, 其他信号退出
+	for ; sig == syscall.SIGHUP; sig = <-done {
+		sdNotify(daemon.StateReloading)
+		reload()
+		sdNotify(daemon.StateReady)
+	}
+	log.Info("daemon.stop", zap.String("signal", sig.String()))
+	sdNotify(daemon.StateStopping)
+	close(stopWatchdog)
+	core.Stop(10 * time.Second)
+	notify.Send(notify.EventDaemonStop, log.Msg("daemon.stop"), map[string]interface{}{"signal": sig.String()})
+	notify.Wait(10 * time.Second)
+	hook.Wait(10 * time.Second)
+	core.ReleaseInstanceLock()
+}
+
+func reload() {
+	viperConfig, config := etc.ViperConfig, etc.Get()
+	if err := etc.Reload(); err != nil {
+		log.Error("daemon.reload_failed", zap.Error(err))
+		return
+	}
+	if err := log.Reload(); err != nil {
+		etc.ViperConfig = viperConfig
+		etc.Set(config)
+		log.Error("daemon.reload_failed", zap.Error(err))
+		return
+	}
+	core.Reload()
+	log.Info("daemon.reloaded", log.Path(etc.ViperConfig.ConfigFileUsed()))
+}
+
+// 通知systemd服务状态, 不是由systemd启动时什么也不做
+func sdNotify(state string) {
+	if _, err := daemon.Notify(state); err != nil {
+		log.Warn("daemon.notify_failed", zap.String("state", state), zap.Error(err))
+	}
 }
@@ -0,0 +1,235 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/sanguohot/dcm-timer/etc"
+	"github.com/sanguohot/dcm-timer/pkg/common/file"
+	"github.com/sanguohot/dcm-timer/pkg/common/log"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 审计动作
+const (
+	ActionDiscover   = "discover"
+	ActionCopy       = "copy"
+	ActionVerify     = "verify"
+	ActionOverwrite  = "overwrite"
+	ActionQuarantine = "quarantine"
+	ActionArchive    = "archive"
+	ActionDelete     = "delete"
+	ActionHook       = "hook"
+	ActionResearch   = "research"
+)
+
+// 审计结果
+const (
+	OutcomeOk     = "ok"
+	OutcomeFailed = "failed"
+)
+
+// 审计日志的一条记录, Hash为上一条记录的Hash与本条记录内容的sha256, 任何修改或删除都会破坏哈希链
+type Record struct {
+	Seq      uint64    `json:"seq"`
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"`
+	Exam     string    `json:"exam"`
+	Src      string    `json:"src,omitempty"`
+	Dst      string    `json:"dst,omitempty"`
+	Size     int64     `json:"size,omitempty"`
+	Sha256   string    `json:"sha256,omitempty"`
+	Outcome  string    `json:"outcome"`
+	Detail   string    `json:"detail,omitempty"`
+	PrevHash string    `json:"prev_hash"`
+	Hash     string    `json:"hash"`
+}
+
+var (
+	lock     sync.Mutex
+	fp       *os.File
+	lastSeq  uint64
+	lastHash string
+)
+
+func (r *Record) calcHash() (string, error) {
+	c := *r
+	c.Hash = ""
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(r.PrevHash), data...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func GetAuditPath() string {
+	return filepath.Join(etc.GetServerDir(), etc.Get().Audit.Path)
+}
+
+// 打开审计日志并校验哈希链, 用于延续哈希链
+// 最后一行不完整是写入中途进程退出或断电留下的, 截断后继续; 完整的记录破坏哈希链时不再追加
+func open() error {
+	if fp != nil {
+		return nil
+	}
+	path := GetAuditPath()
+	if err := file.EnsureDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	seq, hash := uint64(0), ""
+	size, err := scan(func(r *Record) error {
+		if err := checkLink(r, hash); err != nil {
+			return err
+		}
+		seq, hash = r.Seq, r.Hash
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.Size() > size {
+			log.Warn("audit.truncated", log.Path(path), log.Size(info.Size()-size))
+			if err := os.Truncate(path, size); err != nil {
+				return err
+			}
+		}
+	}
+	lastSeq, lastHash = seq, hash
+	fp, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	return err
+}
+
+// 追加一条审计记录, 写入后立即落盘, 失败只记录日志不影响拷贝
+func Append(r Record) {
+	if etc.Get().Audit.Path == "" {
+		return
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if err := appendRecord(&r); err != nil {
+		log.Error("audit.failed", log.Path(GetAuditPath()), zap.String("action", r.Action), log.Exam(r.Exam), zap.Error(err))
+	}
+}
+
+func appendRecord(r *Record) error {
+	if err := open(); err != nil {
+		return err
+	}
+	r.Seq = lastSeq + 1
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	if r.Outcome == "" {
+		r.Outcome = OutcomeOk
+	}
+	r.PrevHash = lastHash
+	hash, err := r.calcHash()
+	if err != nil {
+		return err
+	}
+	r.Hash = hash
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := fp.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := fp.Sync(); err != nil {
+		return err
+	}
+	lastSeq, lastHash = r.Seq, r.Hash
+	return nil
+}
+
+// 逐条读取完整的记录, 返回完整记录的总字节数, 最后一行没有换行符时是不完整的记录, 不读取
+func scan(fn func(r *Record) error) (int64, error) {
+	f, err := os.Open(GetAuditPath())
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	reader := bufio.NewReaderSize(f, 64*1024)
+	var offset int64
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return offset, nil
+		} else if err != nil {
+			return offset, err
+		}
+		var r Record
+		if err := json.Unmarshal(data, &r); err != nil {
+			return offset, fmt.Errorf("审计日志第 %d 行格式错误: %v", line, err)
+		}
+		if err := fn(&r); err != nil {
+			return offset, err
+		}
+		offset += int64(len(data))
+	}
+}
+
+// 校验记录与上一条记录的哈希链
+func checkLink(r *Record, prev string) error {
+	if r.PrevHash != prev {
+		return fmt.Errorf("第 %d 条记录的prev_hash与上一条记录不一致", r.Seq)
+	}
+	hash, err := r.calcHash()
+	if err != nil {
+		return err
+	}
+	if hash != r.Hash {
+		return fmt.Errorf("第 %d 条记录被修改", r.Seq)
+	}
+	return nil
+}
+
+// 按检查和时间范围查询, exam为空时不限检查, 时间为零值时不限
+func Query(exam string, from, to time.Time) ([]Record, error) {
+	records := []Record{}
+	_, err := scan(func(r *Record) error {
+		if exam != "" && r.Exam != exam {
+			return nil
+		}
+		if !from.IsZero() && r.Time.Before(from) {
+			return nil
+		}
+		if !to.IsZero() && !r.Time.Before(to) {
+			return nil
+		}
+		records = append(records, *r)
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	return records, err
+}
+
+// 校验整条哈希链, 返回校验通过的记录数
+func VerifyChain() (int, error) {
+	prev := ""
+	count := 0
+	_, err := scan(func(r *Record) error {
+		if err := checkLink(r, prev); err != nil {
+			return err
+		}
+		prev = r.Hash
+		count++
+		return nil
+	})
+	return count, err
+}
@@ -0,0 +1,134 @@
+package audit
+
+import (
+	"bytes"
+	"github.com/sanguohot/dcm-timer/etc"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 没有设置DCM_TIMER_PATH时, etc按包目录读取etc/config.json, 即本目录下只配置了日志和审计的测试配置
+// GetAuditPath按服务目录拼接, 审计日志换算成相对服务目录的路径写到临时目录
+func useTempLog(t *testing.T) string {
+	base, err := filepath.Abs(etc.GetServerDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := filepath.Rel(base, filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := *etc.Get()
+	c.Audit.Path = rel
+	etc.Set(&c)
+	reopen()
+	t.Cleanup(reopen)
+	return GetAuditPath()
+}
+
+// 关闭审计日志, 下次追加时重新打开并校验, 相当于重启进程
+func reopen() {
+	lock.Lock()
+	defer lock.Unlock()
+	if fp != nil {
+		fp.Close()
+	}
+	fp, lastSeq, lastHash = nil, 0, ""
+}
+
+func appendN(exam string, n int) {
+	for i := 0; i < n; i++ {
+		Append(Record{Action: ActionCopy, Exam: exam, Dst: "/data/" + exam})
+	}
+}
+
+func readLines(t *testing.T, p string) [][]byte {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	// 以换行符结尾时最后一个为空
+	return lines[:len(lines)-1]
+}
+
+func writeLines(t *testing.T, p string, lines [][]byte) {
+	if err := ioutil.WriteFile(p, bytes.Join(lines, nil), 0640); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChain(t *testing.T) {
+	useTempLog(t)
+	appendN("s1", 3)
+	appendN("s2", 2)
+	if n, err := VerifyChain(); err != nil || n != 5 {
+		t.Fatalf("校验 %d 条, %v", n, err)
+	}
+	// 重新打开后延续哈希链
+	reopen()
+	appendN("s1", 1)
+	if n, err := VerifyChain(); err != nil || n != 6 {
+		t.Fatalf("重新打开后校验 %d 条, %v", n, err)
+	}
+	records, err := Query("s1", time.Time{}, time.Time{})
+	if err != nil || len(records) != 4 {
+		t.Fatalf("查询到 %d 条, %v", len(records), err)
+	}
+	for i, r := range records[1:] {
+		if r.Seq <= records[i].Seq {
+			t.Fatalf("序号没有递增: %d, %d", records[i].Seq, r.Seq)
+		}
+	}
+}
+
+func TestTampered(t *testing.T) {
+	p := useTempLog(t)
+	appendN("s1", 3)
+	lines := readLines(t, p)
+
+	modified := append([][]byte(nil), lines...)
+	modified[1] = bytes.Replace(lines[1], []byte(`"exam":"s1"`), []byte(`"exam":"s9"`), 1)
+	writeLines(t, p, modified)
+	if _, err := VerifyChain(); err == nil {
+		t.Fatal("修改记录后应校验失败")
+	}
+
+	writeLines(t, p, [][]byte{lines[0], lines[2]})
+	if _, err := VerifyChain(); err == nil {
+		t.Fatal("删除记录后应校验失败")
+	}
+
+	// 哈希链已被破坏, 重新打开后不再追加
+	reopen()
+	appendN("s1", 1)
+	if got := readLines(t, p); len(got) != 2 {
+		t.Fatalf("哈希链被破坏后仍追加了记录, 现有 %d 行", len(got))
+	}
+}
+
+func TestTornTail(t *testing.T) {
+	p := useTempLog(t)
+	appendN("s1", 2)
+	reopen()
+	// 写入中途退出留下没有换行符的半条记录
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"seq":3,"time":"2026-`)
+	f.Close()
+	if n, err := VerifyChain(); err != nil || n != 2 {
+		t.Fatalf("不完整的最后一行不应计入: 校验 %d 条, %v", n, err)
+	}
+	appendN("s1", 1)
+	if n, err := VerifyChain(); err != nil || n != 3 {
+		t.Fatalf("截断后追加: 校验 %d 条, %v", n, err)
+	}
+	if got := readLines(t, p); len(got) != 3 {
+		t.Fatalf("截断后应有 3 行, 实际 %d 行", len(got))
+	}
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/sanguohot/dcm-timer/pkg/audit"
+	"os"
+	"time"
+)
+
+var (
+	dateLayout = "2006-01-02"
+)
+
+func init() {
+	register("audit", Command{Usage: "按检查或日期范围查询审计日志, -verify校验哈希链", Run: runAudit})
+}
+
+func runAudit(args []string) int {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	exam := fs.String("exam", "", "检查号, 即目标目录名")
+	from := fs.String("from", "", "起始日期(含), 格式2006-01-02")
+	to := fs.String("to", "", "结束日期(含), 格式2006-01-02")
+	asJson := fs.Bool("json", false, "以JSON Lines格式输出")
+	verify := fs.Bool("verify", false, "校验审计日志的哈希链")
+	fs.Parse(args)
+	if *verify {
+		count, err := audit.VerifyChain()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "审计日志校验失败: %v, 此前 %d 条记录校验通过\n", err, count)
+			return 1
+		}
+		fmt.Printf("审计日志校验通过, 共 %d 条记录\n", count)
+		return 0
+	}
+	var fromTime, toTime time.Time
+	var err error
+	if *from != "" {
+		if fromTime, err = time.ParseInLocation(dateLayout, *from, time.Local); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 2
+		}
+	}
+	if *to != "" {
+		if toTime, err = time.ParseInLocation(dateLayout, *to, time.Local); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 2
+		}
+		toTime = toTime.AddDate(0, 0, 1)
+	}
+	records, err := audit.Query(*exam, fromTime, toTime)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	for _, r := range records {
+		if *asJson {
+			data, _ := json.Marshal(r)
+			fmt.Println(string(data))
+			continue
+		}
+		fmt.Printf("%6d %s %-10s %-7s %s %s %s %s\n", r.Seq, r.Time.Format("2006-01-02 15:04:05"), r.Action, r.Outcome, r.Exam, r.Src, r.Dst, r.Detail)
+	}
+	return 0
+}
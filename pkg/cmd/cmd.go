@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// 子命令, 返回值为进程退出码
+type Command struct {
+	Usage string
+	Run   func(args []string) int
+}
+
+var (
+	commands = make(map[string]Command)
+)
+
+func register(name string, command Command) {
+	commands[name] = command
+}
+
+// 执行子命令, name不是已知子命令时返回false
+func Run(name string, args []string) (int, bool) {
+	if name == "help" {
+		PrintUsage()
+		return 0, true
+	}
+	command, ok := commands[name]
+	if !ok {
+		return 0, false
+	}
+	return command.Run(args), true
+}
+
+func PrintUsage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "用法: %s [子命令] [参数]\n不带子命令时启动定时拷贝服务\n\n", os.Args[0])
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].Usage)
+	}
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"github.com/sanguohot/dcm-timer/etc"
+	"github.com/sanguohot/dcm-timer/pkg/core"
+	"github.com/sanguohot/dcm-timer/pkg/crypt"
+	"os"
+	"path/filepath"
+)
+
+func init() {
+	register("keygen", Command{Usage: "生成加密目标文件使用的密钥文件", Run: runKeygen})
+	register("decrypt", Command{Usage: "解密目标文件或检查目录, 检查目录按清单校验", Run: runDecrypt})
+}
+
+func runKeygen(args []string) int {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	out := fs.String("o", etc.GetKeyFilePath(), "密钥文件, 已存在时不覆盖")
+	fs.Parse(args)
+	if *out == "" {
+		fmt.Fprintln(os.Stderr, "没有配置encrypt.key_file, 请用-o指定密钥文件")
+		return 2
+	}
+	key, err := crypt.GenerateKey()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+	fp, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+	defer fp.Close()
+	if _, err := fmt.Fprintln(fp, hex.EncodeToString(key)); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+	fmt.Printf("密钥已写入 %s, 编号 %s, 请另行备份, 丢失后无法解密\n", *out, crypt.KeyId(key))
+	return 0
+}
+
+func runDecrypt(args []string) int {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	out := fs.String("o", ".", "解密到的目录, 检查目录解密到其下的同名目录")
+	check := fs.Bool("check", false, "只校验检查目录, 不写出文件")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "用法: %s decrypt [-o 目录] [-check] 文件或检查...\n密钥按encrypt.key_env和encrypt.key_file读取\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	code := 0
+	for _, arg := range fs.Args() {
+		p := arg
+		if !filepath.IsAbs(p) && !fileExist(p) {
+			p = filepath.Join(etc.GetDstPath(), arg)
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			fmt.Printf("%s\t失败: %s\n", arg, err.Error())
+			code = 1
+			continue
+		}
+		if !info.IsDir() {
+			target, err := core.ExtractFile(p, *out)
+			if err != nil {
+				fmt.Printf("%s\t失败: %s\n", arg, err.Error())
+				code = 1
+				continue
+			}
+			fmt.Printf("%s\t已解密到 %s\n", arg, target)
+			continue
+		}
+		outDir := ""
+		if !*check {
+			outDir = filepath.Join(*out, filepath.Base(p))
+		}
+		m, err := core.ExtractExam(p, outDir)
+		if err != nil {
+			fmt.Printf("%s\t失败: %s\n", arg, err.Error())
+			code = 1
+			continue
+		}
+		if *check {
+			fmt.Printf("%s\t校验通过, %d 个文件\n", arg, len(m.Files))
+		} else {
+			fmt.Printf("%s\t已解密 %d 个文件到 %s\n", arg, len(m.Files), outDir)
+		}
+	}
+	return code
+}
+
+func fileExist(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
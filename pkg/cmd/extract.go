@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"github.com/sanguohot/dcm-timer/etc"
+	"github.com/sanguohot/dcm-timer/pkg/core"
+	"os"
+	"path/filepath"
+)
+
+func init() {
+	register("extract", Command{Usage: "解压目标目录中的检查并按清单校验", Run: runExtract})
+}
+
+func runExtract(args []string) int {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	out := fs.String("o", ".", "解压到的目录, 每个检查解压到其下的同名目录")
+	check := fs.Bool("check", false, "只校验, 不写出文件")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "用法: %s extract [-o 目录] [-check] 检查...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	code := 0
+	for _, exam := range fs.Args() {
+		dir := exam
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(etc.GetDstPath(), exam)
+		}
+		outDir := ""
+		if !*check {
+			outDir = filepath.Join(*out, filepath.Base(dir))
+		}
+		m, err := core.ExtractExam(dir, outDir)
+		if err != nil {
+			fmt.Printf("%s\t失败: %s\n", exam, err.Error())
+			code = 1
+			continue
+		}
+		if *check {
+			fmt.Printf("%s\t校验通过, %d 个文件\n", exam, len(m.Files))
+		} else {
+			fmt.Printf("%s\t已解压 %d 个文件到 %s\n", exam, len(m.Files), outDir)
+		}
+	}
+	return code
+}
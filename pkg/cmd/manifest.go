@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"github.com/sanguohot/dcm-timer/etc"
+	"github.com/sanguohot/dcm-timer/pkg/core"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	register("manifest", Command{Usage: "按manifest.json检查目标检查目录是否完整", Run: runManifest})
+}
+
+func runManifest(args []string) int {
+	fs := flag.NewFlagSet("manifest", flag.ExitOnError)
+	hash := fs.Bool("hash", false, "同时校验文件的sha256")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "用法: %s manifest [-hash] [检查...]\n不指定检查时检查目标目录下的全部检查\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	exams := fs.Args()
+	if len(exams) == 0 {
+		infos, err := ioutil.ReadDir(etc.GetDstPath())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 2
+		}
+		for _, info := range infos {
+			if info.IsDir() && strings.HasPrefix(info.Name(), "s") {
+				exams = append(exams, info.Name())
+			}
+		}
+	}
+	code := 0
+	for _, exam := range exams {
+		dir := exam
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(etc.GetDstPath(), exam)
+		}
+		if err := core.ValidateExamDir(dir, *hash); err != nil {
+			if os.IsNotExist(err) {
+				fmt.Printf("%s\t缺少清单或文件: %s\n", exam, err.Error())
+			} else {
+				fmt.Printf("%s\t不完整: %s\n", exam, err.Error())
+			}
+			code = 1
+			continue
+		}
+		fmt.Printf("%s\t完整\n", exam)
+	}
+	return code
+}
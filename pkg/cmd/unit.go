@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"github.com/sanguohot/dcm-timer/etc"
+	"github.com/sanguohot/dcm-timer/pkg/daemon"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+func init() {
+	register("systemd-unit", Command{Usage: "生成systemd unit文件, 以Type=notify前台运行", Run: runUnit})
+}
+
+func runUnit(args []string) int {
+	exe, _ := os.Executable()
+	serverDir, _ := filepath.Abs(etc.GetServerDir())
+	fs := flag.NewFlagSet("systemd-unit", flag.ExitOnError)
+	opts := daemon.UnitOptions{}
+	fs.StringVar(&opts.Exec, "exec", exe, "程序路径")
+	fs.StringVar(&opts.Path, "path", serverDir, "DCM_TIMER_PATH, 配置和状态目录的根")
+	fs.StringVar(&opts.Type, "type", "production", "DCM_TIMER_TYPE")
+	fs.StringVar(&opts.User, "user", "", "运行用户, 为空时使用root")
+	fs.StringVar(&opts.Group, "group", "", "运行用户组")
+	fs.IntVar(&opts.Watchdog, "watchdog", 120, "看门狗超时秒数, 0为不启用")
+	output := fs.String("o", "", "写入文件, 如/etc/systemd/system/dcm-timer.service, 为空时输出到标准输出")
+	fs.Parse(args)
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		fp, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 2
+		}
+		defer fp.Close()
+		w = fp
+	}
+	if err := daemon.WriteUnit(w, opts); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+	return 0
+}
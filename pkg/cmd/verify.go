@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/sanguohot/dcm-timer/etc"
+	"github.com/sanguohot/dcm-timer/pkg/common/log"
+	"github.com/sanguohot/dcm-timer/pkg/core"
+	"go.uber.org/zap"
+	"os"
+)
+
+func init() {
+	register("verify", Command{Usage: "核对源目录与目标目录, 发现缺失、多余和不一致的文件", Run: runVerify})
+}
+
+func runVerify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	asJson := fs.Bool("json", false, "以JSON格式输出报告")
+	hash := fs.Bool("hash", etc.Get().Verify.Hash, "比较文件的sha256")
+	fs.Parse(args)
+	// 命令行输出报告, 只保留错误日志
+	log.Atom.SetLevel(zap.ErrorLevel)
+	report, err := core.RunVerify(context.Background(), *hash)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		if report == nil {
+			return 2
+		}
+	}
+	if *asJson {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(data))
+	} else {
+		report.WriteText(os.Stdout)
+	}
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
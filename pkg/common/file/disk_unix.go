@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import "syscall"
+
+// 路径所在文件系统的可用空间(字节), 不含只有root可用的保留空间
+func DiskFree(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
+
+// 路径所在文件系统的总空间和可用空间(字节)
+func DiskUsage(dir string) (uint64, uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, 0, err
+	}
+	return uint64(stat.Blocks) * uint64(stat.Bsize), uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
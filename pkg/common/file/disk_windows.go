@@ -0,0 +1,40 @@
+package file
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procGetDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+)
+
+// 路径所在磁盘对当前用户的可用空间(字节)
+func DiskFree(dir string) (uint64, error) {
+	p, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var free, total, totalFree uint64
+	r, _, err := procGetDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(&free)), uintptr(unsafe.Pointer(&total)), uintptr(unsafe.Pointer(&totalFree)))
+	if r == 0 {
+		return 0, err
+	}
+	return free, nil
+}
+
+// 路径所在磁盘的总空间和对当前用户的可用空间(字节)
+func DiskUsage(dir string) (uint64, uint64, error) {
+	p, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	var free, total, totalFree uint64
+	r, _, err := procGetDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(&free)), uintptr(unsafe.Pointer(&total)), uintptr(unsafe.Pointer(&totalFree)))
+	if r == 0 {
+		return 0, 0, err
+	}
+	return total, free, nil
+}
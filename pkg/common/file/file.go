@@ -1,14 +1,31 @@
 package file
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"github.com/pkg/errors"
 	"github.com/sanguohot/dcm-timer/pkg/common/log"
 	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 )
 
+var (
+	// 文件已被其他进程锁定
+	ErrLocked = errors.New("文件已被其他进程锁定")
+)
+
+type progressKey struct{}
+
+// 拷贝过程中每次读取后以已读取的字节数回调, 用于推送拷贝进度
+func WithProgress(ctx context.Context, progress func(n int64)) context.Context {
+	return context.WithValue(ctx, progressKey{}, progress)
+}
+
 func IsFileExist(output, name string) bool {
 	if _, err := os.Stat(path.Join(output, name)); err == nil {
 		// path/to/whatever exists
@@ -67,29 +84,114 @@ func Copy(src, dst string) error {
 	return nil
 }
 
-func StandardCopy(src, dst string) (int64, error) {
+func StandardCopy(ctx context.Context, src, dst string) (int64, error) {
+	n, _, err := copyContext(ctx, src, dst, false, nil)
+	return n, err
+}
+
+// 拷贝的同时计算sha256, 避免为了校验再读一遍源文件
+func StandardCopyWithHash(ctx context.Context, src, dst string) (int64, string, error) {
+	return copyContext(ctx, src, dst, true, nil)
+}
+
+// 拷贝时经encode编码(如压缩、加密)后写入dst, 返回的字节数和sha256均为源文件的
+func EncodeCopyWithHash(ctx context.Context, src, dst string, encode func(io.Writer) (io.WriteCloser, error)) (int64, string, error) {
+	return copyContext(ctx, src, dst, true, encode)
+}
+
+// ctx结束后关闭源文件和目标文件, 阻塞中的读写随之出错返回, 拷贝中止后才返回ctx.Err(), 不会在返回后继续写dst
+func copyContext(ctx context.Context, src, dst string, hash bool, encode func(io.Writer) (io.WriteCloser, error)) (int64, string, error) {
 	sourceFileStat, err := os.Stat(src)
 	if err != nil {
-		return 0, err
+		return 0, "", err
 	}
 
 	if !sourceFileStat.Mode().IsRegular() {
-		return 0, fmt.Errorf("%s is not a regular file", src)
+		return 0, "", fmt.Errorf("%s is not a regular file", src)
 	}
 
 	source, err := os.Open(src)
 	if err != nil {
-		return 0, err
+		return 0, "", err
 	}
 	defer source.Close()
 
 	destination, err := os.Create(dst)
 	if err != nil {
-		return 0, err
+		return 0, "", err
 	}
 	defer destination.Close()
-	nBytes, err := io.Copy(destination, source)
-	return nBytes, err
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			source.Close()
+			destination.Close()
+		case <-stop:
+		}
+	}()
+	n, h, err := standardCopy(ctx, source, destination, hash, encode)
+	if ctx.Err() != nil {
+		return 0, "", ctx.Err()
+	}
+	return n, h, err
+}
+
+func standardCopy(ctx context.Context, source, destination *os.File, hash bool, encode func(io.Writer) (io.WriteCloser, error)) (int64, string, error) {
+	var err error
+	var w io.Writer = destination
+	var cw io.WriteCloser
+	if encode != nil {
+		if cw, err = encode(destination); err != nil {
+			return 0, "", err
+		}
+		w = cw
+	}
+	h := sha256.New()
+	if hash {
+		w = io.MultiWriter(w, h)
+	}
+	nBytes, err := io.Copy(w, newContextReader(ctx, source))
+	if err != nil {
+		return nBytes, "", err
+	}
+	// 压缩、加密流需要先写完尾部
+	if cw != nil {
+		if err := cw.Close(); err != nil {
+			return nBytes, "", err
+		}
+	}
+	if !hash {
+		return nBytes, "", destination.Close()
+	}
+	return nBytes, hex.EncodeToString(h.Sum(nil)), destination.Close()
+}
+
+// 每次读取前检查ctx, 取消后拷贝在下一次读取时中止
+type contextReader struct {
+	ctx      context.Context
+	r        io.Reader
+	n        int64
+	progress func(n int64)
+}
+
+func newContextReader(ctx context.Context, r io.Reader) *contextReader {
+	progress, _ := ctx.Value(progressKey{}).(func(n int64))
+	return &contextReader{ctx: ctx, r: r, progress: progress}
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if r.progress != nil && n > 0 {
+		r.progress(r.n)
+	}
+	return n, err
 }
 
 func EnsureDir(dir string) error {
@@ -98,3 +200,25 @@ func EnsureDir(dir string) error {
 	}
 	return nil
 }
+
+// 计算文件的sha256
+func HashFile(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// 移动文件, 目标目录不存在时自动创建, 只支持同一文件系统内移动
+func Move(src, dst string) error {
+	if err := EnsureDir(filepath.Dir(dst)); err != nil {
+		return err
+	}
+	return os.Rename(src, dst)
+}
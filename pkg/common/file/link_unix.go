@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"os"
+	"syscall"
+)
+
+// 文件的硬链接数
+func LinkCount(p string) (uint64, error) {
+	info, err := os.Lstat(p)
+	if err != nil {
+		return 0, err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 1, nil
+	}
+	return uint64(st.Nlink), nil
+}
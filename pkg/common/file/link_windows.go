@@ -0,0 +1,24 @@
+package file
+
+import (
+	"syscall"
+)
+
+// 文件的硬链接数
+func LinkCount(p string) (uint64, error) {
+	name, err := syscall.UTF16PtrFromString(p)
+	if err != nil {
+		return 0, err
+	}
+	h, err := syscall.CreateFile(name, 0, syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer syscall.CloseHandle(h)
+	var d syscall.ByHandleFileInformation
+	if err := syscall.GetFileInformationByHandle(h, &d); err != nil {
+		return 0, err
+	}
+	return uint64(d.NumberOfLinks), nil
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import "syscall"
+
+// 对文件加非阻塞的排他锁, 已被其他进程锁定时返回ErrLocked, 进程退出后锁自动释放
+func LockFile(fd uintptr) error {
+	if err := syscall.Flock(int(fd), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		if err == syscall.EWOULDBLOCK {
+			return ErrLocked
+		}
+		return err
+	}
+	return nil
+}
+
+func UnlockFile(fd uintptr) error {
+	return syscall.Flock(int(fd), syscall.LOCK_UN)
+}
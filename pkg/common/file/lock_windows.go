@@ -0,0 +1,39 @@
+package file
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procLockFileEx   = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+	procUnlockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	errorLockViolation      = syscall.Errno(33)
+)
+
+// 对文件加非阻塞的排他锁, 已被其他进程锁定时返回ErrLocked, 进程退出后锁自动释放
+func LockFile(fd uintptr) error {
+	ol := new(syscall.Overlapped)
+	r, _, err := procLockFileEx.Call(fd, lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(ol)))
+	if r == 0 {
+		if err == errorLockViolation {
+			return ErrLocked
+		}
+		return err
+	}
+	return nil
+}
+
+func UnlockFile(fd uintptr) error {
+	ol := new(syscall.Overlapped)
+	r, _, err := procUnlockFileEx.Call(fd, 0, 1, 0, uintptr(unsafe.Pointer(ol)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package log
+
+const (
+	LangZh = "zh"
+	LangEn = "en"
+	// 配置的语言在目录中没有对应消息时使用
+	defaultLang = LangZh
+)
+
+// 事件名 => 语言 => 消息, 事件名和字段名是稳定的, 消息只用于阅读
+var catalog = map[string]map[string]string{
+	// 检索
+	"scan.path_missing":     {LangZh: "找不到路径", LangEn: "path not found"},
+	"scan.dead_letter_skip": {LangZh: "检查已多次拷贝失败, 跳过", LangEn: "exam dead-lettered, skipped"},
+	"scan.discard":          {LangZh: "丢弃不完整的检查", LangEn: "incomplete exam discarded"},
+	"scan.prune_depth":      {LangZh: "超过最大检索深度, 跳过目录", LangEn: "directory skipped, max depth exceeded"},
+	"scan.prune_exclude":    {LangZh: "目录被排除, 跳过", LangEn: "directory skipped, excluded"},
+	"scan.prune_date":       {LangZh: "日期目录早于起始时间, 跳过", LangEn: "directory skipped, date before since"},
+	"scan.prune_mtime":      {LangZh: "目录修改时间早于起始时间, 跳过", LangEn: "directory skipped, mtime before since"},
+	"scan.since_invalid":    {LangZh: "非法的起始时间配置", LangEn: "invalid since setting"},
+	"scan.start":            {LangZh: "开始检索源目录", LangEn: "scanning source"},
+	"scan.walk_failed":      {LangZh: "检索源目录失败", LangEn: "scanning source failed"},
+	"scan.cancelled":        {LangZh: "检索已取消", LangEn: "scan cancelled"},
+	"scan.done":             {LangZh: "检索完毕", LangEn: "scan finished"},
+	// 稳定性检查
+	"stability.stat_failed":   {LangZh: "读取文件信息失败", LangEn: "stat failed"},
+	"stability.check":         {LangZh: "检查目录是否仍在写入", LangEn: "checking whether exam is still being written"},
+	"stability.file_modified": {LangZh: "文件修改时间过近, 推迟拷贝", LangEn: "file modified recently, copy deferred"},
+	"stability.dir_grown":     {LangZh: "检查文件大小仍在变化, 推迟拷贝", LangEn: "exam files still growing, copy deferred"},
+	"stability.stable":        {LangZh: "目录已稳定", LangEn: "exam is stable"},
+	// 拷贝
+	"copy.job":              {LangZh: "拷贝者开始处理检查", LangEn: "worker picked up exam"},
+	"copy.workers_resized":  {LangZh: "拷贝者数量已调整", LangEn: "copy worker count changed"},
+	"copy.deferred_disk":    {LangZh: "目标磁盘空间不足, 推迟拷贝", LangEn: "copy deferred, destination disk low"},
+	"copy.deferred_writing": {LangZh: "检查仍在写入, 推迟拷贝", LangEn: "copy deferred, exam still being written"},
+	"copy.job_failed":       {LangZh: "检查拷贝失败", LangEn: "exam copy failed"},
+	"copy.dead_letter":      {LangZh: "检查多次拷贝失败, 停止重试", LangEn: "exam dead-lettered after repeated failures"},
+	"copy.retry":            {LangZh: "拷贝失败, 稍后重试", LangEn: "copy failed, retrying"},
+	"copy.src_missing":      {LangZh: "源文件不存在, 跳过", LangEn: "source file missing, skipped"},
+	"copy.conflict":         {LangZh: "目标文件已存在", LangEn: "destination file exists"},
+	"copy.failed":           {LangZh: "拷贝文件失败", LangEn: "file copy failed"},
+	"copy.success":          {LangZh: "拷贝文件成功", LangEn: "file copied"},
+	"copy.queued":           {LangZh: "检查已加入拷贝队列", LangEn: "exams queued for copy"},
+	"copy.deferred_busy":    {LangZh: "检查正被其他任务处理, 推迟拷贝", LangEn: "copy deferred, exam busy"},
+	"copy.cancelled":        {LangZh: "拷贝已取消", LangEn: "copy cancelled"},
+	"copy.deferred_paused":  {LangZh: "拷贝已暂停, 推迟拷贝", LangEn: "copy deferred, paused"},
+	"copy.skipped_paused":   {LangZh: "拷贝已暂停, 跳过本次检索", LangEn: "scan skipped, paused"},
+	"copy.manual":           {LangZh: "手动加入拷贝队列", LangEn: "exam queued manually"},
+	"copy.dedup":            {LangZh: "目标文件与已有内容相同, 改为硬链接", LangEn: "destination file deduplicated via hardlink"},
+	"copy.dedup_failed":     {LangZh: "目标文件去重失败", LangEn: "deduplicating destination file failed"},
+	"copy.manifest":         {LangZh: "写入检查清单", LangEn: "exam manifest written"},
+	// 暂停
+	"pause.started":        {LangZh: "暂停拷贝", LangEn: "copying paused"},
+	"pause.ended":          {LangZh: "恢复拷贝", LangEn: "copying resumed"},
+	"pause.check_failed":   {LangZh: "检查源目录活动失败", LangEn: "checking source activity failed"},
+	"pause.window_invalid": {LangZh: "非法的配置项：暂停时间窗口", LangEn: "invalid pause window setting"},
+	// 队列
+	"queue.starved":         {LangZh: "检查等待过久, 优先拷贝", LangEn: "exam waited too long, promoted"},
+	"queue.priority_failed": {LangZh: "读取检查优先级失败", LangEn: "reading exam priority failed"},
+	// 拷贝后处理
+	"post_copy.marked":          {LangZh: "写入拷贝标记", LangEn: "copied marker written"},
+	"post_copy.archived":        {LangZh: "归档源文件", LangEn: "source file archived"},
+	"post_copy.marker_invalid":  {LangZh: "拷贝标记无法读取", LangEn: "copied marker unreadable"},
+	"post_copy.source_modified": {LangZh: "源文件在拷贝后被修改, 重新拷贝", LangEn: "source modified after copy, copying again"},
+	"post_copy.deleted":         {LangZh: "删除已拷贝源文件成功", LangEn: "copied source deleted"},
+	"post_copy.delete_failed":   {LangZh: "删除已拷贝源文件失败", LangEn: "deleting copied source failed"},
+	// 清除
+	"clean.invalid_hold_days": {LangZh: "非法的配置项：保留天数", LangEn: "invalid hold_days setting"},
+	"clean.path_missing":      {LangZh: "找不到路径", LangEn: "path not found"},
+	"clean.start":             {LangZh: "开始清除超过保留期限的数据", LangEn: "cleaning exams past hold period"},
+	"clean.walk_failed":       {LangZh: "检索目标目录失败", LangEn: "scanning destination failed"},
+	"clean.delete_failed":     {LangZh: "删除目录失败", LangEn: "deleting directory failed"},
+	"clean.deleted":           {LangZh: "删除目录成功", LangEn: "directory deleted"},
+	"clean.busy":              {LangZh: "检查正在拷贝, 暂不删除", LangEn: "exam is being copied, not deleted"},
+	"clean.dir_skipped":       {LangZh: "目录名不包含日期, 不是检查目录, 跳过", LangEn: "directory name has no date, not an exam, skipped"},
+	"clean.emergency":         {LangZh: "目标磁盘空间不足, 从最旧的检查开始删除", LangEn: "destination disk low, deleting oldest exams"},
+	"clean.store_gc":          {LangZh: "回收不再引用的存储内容", LangEn: "unreferenced store blobs removed"},
+	"clean.store_failed":      {LangZh: "回收存储内容失败", LangEn: "removing unreferenced store blobs failed"},
+	"clean.incomplete":        {LangZh: "目标检查与清单不一致", LangEn: "exam does not match its manifest"},
+	"clean.done":              {LangZh: "清除数据完毕", LangEn: "clean finished"},
+	// 磁盘
+	"disk.low":       {LangZh: "目标磁盘空间不足, 暂停拷贝新的检查", LangEn: "destination disk low, new copies paused"},
+	"disk.recovered": {LangZh: "目标磁盘空间恢复, 继续拷贝", LangEn: "destination disk recovered, copies resumed"},
+	// 核对
+	"verify.done":   {LangZh: "核对完毕", LangEn: "verify finished"},
+	"verify.failed": {LangZh: "核对失败", LangEn: "verify failed"},
+	// 任务
+	"task.done":             {LangZh: "任务执行完毕", LangEn: "task finished"},
+	"task.cancelled":        {LangZh: "任务已取消", LangEn: "task cancelled"},
+	"task.timeout":          {LangZh: "任务运行超时, 已取消", LangEn: "task timed out and was cancelled"},
+	"task.cancel_requested": {LangZh: "收到取消任务请求", LangEn: "task cancellation requested"},
+	"task.stop_timeout":     {LangZh: "等待任务退出超时", LangEn: "timed out waiting for tasks to stop"},
+	"task.overlap":          {LangZh: "上一次任务仍在运行, 跳过本次", LangEn: "previous run still in progress, skipped"},
+	"task.overrun":          {LangZh: "任务耗时超过检索间隔", LangEn: "task took longer than scan interval"},
+	// 其他
+	"daemon.start":         {LangZh: "dcm-timer启动", LangEn: "dcm-timer started"},
+	"daemon.stop":          {LangZh: "dcm-timer收到信号, 退出", LangEn: "dcm-timer received signal, exiting"},
+	"daemon.reloaded":      {LangZh: "重新加载配置成功", LangEn: "configuration reloaded"},
+	"daemon.reload_failed": {LangZh: "重新加载配置失败, 保留原配置", LangEn: "configuration reload failed, keeping previous"},
+	"daemon.notify_failed": {LangZh: "通知systemd服务状态失败", LangEn: "notifying systemd failed"},
+	"instance.locked":      {LangZh: "获得实例锁", LangEn: "instance lock acquired"},
+	"instance.lock_failed": {LangZh: "获取实例锁失败, 退出", LangEn: "failed to acquire instance lock, exiting"},
+	"instance.stale_pid":   {LangZh: "上一个实例未正常退出, 覆盖残留的PID文件", LangEn: "previous instance did not exit cleanly, replacing stale PID file"},
+	"research.exported":    {LangZh: "已导出去标识的研究数据", LangEn: "de-identified exam exported for research"},
+	"research.failed":      {LangZh: "导出研究数据失败, 不影响临床拷贝", LangEn: "research export failed, clinical copy not affected"},
+	"research.raw_refused": {LangZh: "头文件可能含有患者信息且不能去标识, 不导出到研究目录", LangEn: "header files may contain patient data and cannot be de-identified, not exported for research"},
+	"hook.invalid":         {LangZh: "非法的配置项：拷贝后处理钩子类型", LangEn: "invalid post-copy hook type"},
+	"hook.retry":           {LangZh: "钩子执行失败, 稍后重试", LangEn: "post-copy hook failed, retrying"},
+	"hook.failed":          {LangZh: "钩子执行失败", LangEn: "post-copy hook failed"},
+	"hook.success":         {LangZh: "钩子执行成功", LangEn: "post-copy hook succeeded"},
+	"hook.save_failed":     {LangZh: "保存钩子执行结果失败", LangEn: "saving post-copy hook results failed"},
+	"hook.wait_timeout":    {LangZh: "等待钩子结束超时, 取消剩余的钩子", LangEn: "timed out waiting for hooks, cancelling the rest"},
+	"hook.unchanged":       {LangZh: "没有写入文件且清单没有变化, 不执行钩子", LangEn: "no files written and manifest unchanged, hooks not triggered"},
+	"notify.sent":          {LangZh: "通知发送成功", LangEn: "notification sent"},
+	"notify.failed":        {LangZh: "通知发送失败", LangEn: "notification failed"},
+	"server.failed":        {LangZh: "管理接口启动失败", LangEn: "management API failed"},
+	"server.no_token":      {LangZh: "管理接口没有配置令牌, 只接受本机的修改请求", LangEn: "no management token configured, only local requests may change state"},
+	"audit.failed":         {LangZh: "写入审计记录失败", LangEn: "writing audit record failed"},
+	"audit.truncated":      {LangZh: "审计日志最后一条记录不完整, 已截断", LangEn: "audit log ended with an incomplete record, truncated"},
+}
@@ -0,0 +1,98 @@
+package log
+
+import (
+	"github.com/sanguohot/dcm-timer/etc"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"time"
+)
+
+var (
+	// 跳过事件函数本身, 调用位置指向业务代码
+	eventLogger *zap.Logger
+)
+
+// 事件对应的日志消息, 语言由etc.Get().Log.Lang决定, 目录中没有的事件原样返回事件名
+func Msg(event string) string {
+	messages, ok := catalog[event]
+	if !ok {
+		return event
+	}
+	if msg, ok := messages[etc.GetLogLang()]; ok {
+		return msg
+	}
+	return messages[defaultLang]
+}
+
+func withEvent(event string, fields []zap.Field) []zap.Field {
+	return append([]zap.Field{zap.String("event", event)}, fields...)
+}
+
+func Debug(event string, fields ...zap.Field) {
+	if !enabled(event, zapcore.DebugLevel) {
+		return
+	}
+	eventLogger.Debug(Msg(event), withEvent(event, fields)...)
+}
+
+func Info(event string, fields ...zap.Field) {
+	if !enabled(event, zapcore.InfoLevel) {
+		return
+	}
+	eventLogger.Info(Msg(event), withEvent(event, fields)...)
+}
+
+func Warn(event string, fields ...zap.Field) {
+	if !enabled(event, zapcore.WarnLevel) {
+		return
+	}
+	eventLogger.Warn(Msg(event), withEvent(event, fields)...)
+}
+
+func Error(event string, fields ...zap.Field) {
+	if !enabled(event, zapcore.ErrorLevel) {
+		return
+	}
+	eventLogger.Error(Msg(event), withEvent(event, fields)...)
+}
+
+func Fatal(event string, fields ...zap.Field) {
+	eventLogger.Fatal(Msg(event), withEvent(event, fields)...)
+}
+
+// 统一的字段名, 便于在日志系统中查询
+func Exam(name string) zap.Field {
+	return zap.String("exam", name)
+}
+
+func Worker(id int) zap.Field {
+	return zap.Int("worker", id)
+}
+
+func Src(path string) zap.Field {
+	return zap.String("src", path)
+}
+
+func Dst(path string) zap.Field {
+	return zap.String("dst", path)
+}
+
+func Dir(path string) zap.Field {
+	return zap.String("dir", path)
+}
+
+func Path(path string) zap.Field {
+	return zap.String("path", path)
+}
+
+func Size(size int64) zap.Field {
+	return zap.Int64("size", size)
+}
+
+func Count(count int) zap.Field {
+	return zap.Int("count", count)
+}
+
+func Elapsed(d time.Duration) zap.Field {
+	return zap.Float64("elapsed", d.Seconds())
+}
@@ -0,0 +1,56 @@
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/sanguohot/dcm-timer/etc"
+	"go.uber.org/zap/zapcore"
+	"io"
+	"net"
+)
+
+var (
+	journaldSocket = "/run/systemd/journal/socket"
+)
+
+// 通过journald原生协议写入, 级别映射为PRIORITY
+func newJournaldCore(enc zapcore.Encoder) (zapcore.Core, io.Closer, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, nil, err
+	}
+	tag := etc.Get().Log.Syslog.Tag
+	return &levelCore{enc: enc, write: func(level zapcore.Level, line []byte) error {
+		var b bytes.Buffer
+		b.WriteString("PRIORITY=")
+		b.WriteString(journaldPriority(level))
+		b.WriteByte('\n')
+		if tag != "" {
+			b.WriteString("SYSLOG_IDENTIFIER=")
+			b.WriteString(tag)
+			b.WriteByte('\n')
+		}
+		// 消息可能包含换行, 使用带长度的二进制格式
+		b.WriteString("MESSAGE\n")
+		binary.Write(&b, binary.LittleEndian, uint64(len(line)))
+		b.Write(line)
+		b.WriteByte('\n')
+		_, err := conn.Write(b.Bytes())
+		return err
+	}}, conn, nil
+}
+
+func journaldPriority(level zapcore.Level) string {
+	switch level {
+	case zapcore.DebugLevel:
+		return "7"
+	case zapcore.InfoLevel:
+		return "6"
+	case zapcore.WarnLevel:
+		return "4"
+	case zapcore.ErrorLevel:
+		return "3"
+	default:
+		return "2"
+	}
+}
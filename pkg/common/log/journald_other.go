@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package log
+
+import (
+	"errors"
+	"go.uber.org/zap/zapcore"
+	"io"
+)
+
+func newJournaldCore(enc zapcore.Encoder) (zapcore.Core, io.Closer, error) {
+	return nil, nil, errors.New("只有linux支持journald")
+}
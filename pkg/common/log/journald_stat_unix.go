@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package log
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// 与JOURNAL_STREAM相同的"设备号:inode"格式
+func fileDevIno(info os.FileInfo) string {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", st.Dev, st.Ino)
+}
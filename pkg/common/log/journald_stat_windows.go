@@ -0,0 +1,7 @@
+package log
+
+import "os"
+
+func fileDevIno(info os.FileInfo) string {
+	return ""
+}
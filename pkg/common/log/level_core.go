@@ -0,0 +1,41 @@
+package log
+
+import (
+	"bytes"
+	"go.uber.org/zap/zapcore"
+)
+
+// 需要按级别写入的输出, 如syslog和journald, 级别已由swapCore过滤
+type levelCore struct {
+	enc   zapcore.Encoder
+	write func(level zapcore.Level, line []byte) error
+}
+
+func (c *levelCore) Enabled(zapcore.Level) bool {
+	return true
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	enc := c.enc.Clone()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	return &levelCore{enc: enc, write: c.write}
+}
+
+func (c *levelCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return ce.AddCore(ent, c)
+}
+
+func (c *levelCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+	return c.write(ent.Level, bytes.TrimRight(buf.Bytes(), "\n"))
+}
+
+func (c *levelCore) Sync() error {
+	return nil
+}
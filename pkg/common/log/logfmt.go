@@ -0,0 +1,94 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	bufferPool = buffer.NewPool()
+)
+
+// key=value格式, 固定字段在前, 其余字段按名称排序, event排在最前便于检索
+type logfmtEncoder struct {
+	*zapcore.MapObjectEncoder
+}
+
+func newLogfmtEncoder() zapcore.Encoder {
+	return &logfmtEncoder{zapcore.NewMapObjectEncoder()}
+}
+
+func (e *logfmtEncoder) Clone() zapcore.Encoder {
+	clone := &logfmtEncoder{zapcore.NewMapObjectEncoder()}
+	for k, v := range e.Fields {
+		clone.Fields[k] = v
+	}
+	return clone
+}
+
+func (e *logfmtEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	enc := e.Clone().(*logfmtEncoder)
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	buf := bufferPool.Get()
+	appendLogfmt(buf, "ts", ent.Time.Format("2006-01-02T15:04:05.000Z0700"))
+	appendLogfmt(buf, "level", ent.Level.String())
+	if ent.Caller.Defined {
+		appendLogfmt(buf, "caller", ent.Caller.TrimmedPath())
+	}
+	appendLogfmt(buf, "msg", ent.Message)
+	if event, ok := enc.Fields["event"]; ok {
+		appendLogfmt(buf, "event", event)
+		delete(enc.Fields, "event")
+	}
+	keys := make([]string, 0, len(enc.Fields))
+	for k := range enc.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		appendLogfmt(buf, k, enc.Fields[k])
+	}
+	if ent.Stack != "" {
+		appendLogfmt(buf, "stacktrace", ent.Stack)
+	}
+	buf.AppendByte('\n')
+	return buf, nil
+}
+
+func appendLogfmt(buf *buffer.Buffer, key string, value interface{}) {
+	if buf.Len() > 0 {
+		buf.AppendByte(' ')
+	}
+	buf.AppendString(key)
+	buf.AppendByte('=')
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case time.Time:
+		s = v.Format(time.RFC3339Nano)
+	case time.Duration:
+		s = v.String()
+	case []interface{}, map[string]interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			s = fmt.Sprint(v)
+		} else {
+			s = string(data)
+		}
+	default:
+		s = fmt.Sprint(v)
+	}
+	if s == "" || strings.ContainsAny(s, " =\"\t\r\n") {
+		s = strconv.Quote(s)
+	}
+	buf.AppendString(s)
+}
@@ -0,0 +1,34 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package log
+
+import (
+	"github.com/sanguohot/dcm-timer/etc"
+	"go.uber.org/zap/zapcore"
+	"io"
+	"log/syslog"
+)
+
+// 写入本机或远程syslog, network和address为空时使用本机
+func newSyslogCore(enc zapcore.Encoder) (zapcore.Core, io.Closer, error) {
+	cfg := etc.Get().Log.Syslog
+	w, err := syslog.Dial(cfg.Network, cfg.Address, syslog.LOG_INFO|syslog.LOG_DAEMON, cfg.Tag)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &levelCore{enc: enc, write: func(level zapcore.Level, line []byte) error {
+		switch level {
+		case zapcore.DebugLevel:
+			return w.Debug(string(line))
+		case zapcore.InfoLevel:
+			return w.Info(string(line))
+		case zapcore.WarnLevel:
+			return w.Warning(string(line))
+		case zapcore.ErrorLevel:
+			return w.Err(string(line))
+		default:
+			return w.Crit(string(line))
+		}
+	}}, w, nil
+}
@@ -0,0 +1,11 @@
+package log
+
+import (
+	"errors"
+	"go.uber.org/zap/zapcore"
+	"io"
+)
+
+func newSyslogCore(enc zapcore.Encoder) (zapcore.Core, io.Closer, error) {
+	return nil, nil, errors.New("windows不支持syslog")
+}
@@ -1,11 +1,30 @@
 package log
 
 import (
+	"fmt"
 	"github.com/sanguohot/dcm-timer/etc"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/natefinch/lumberjack.v2"
+	"io"
 	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// 日志输出
+const (
+	OutputStdout   = "stdout"
+	OutputFile     = "file"
+	OutputSyslog   = "syslog"
+	OutputJournald = "journald"
+)
+
+// 日志格式
+const (
+	EncoderJson    = "json"
+	EncoderConsole = "console"
+	EncoderLogfmt  = "logfmt"
 )
 
 var (
@@ -13,43 +32,226 @@ var (
 	Logger *zap.Logger
 	// Atom.SetLevel(zap.DebugLevel) 程序运行时动态级别
 	Atom zap.AtomicLevel
+	// 当前生效的输出, 重新加载配置时整体替换, 已创建的Logger不受影响
+	outputs atomic.Value
+	// 模块 => 级别, 模块即事件名中第一个点之前的部分, 如copy.success属于copy
+	moduleLevels atomic.Value
 )
 
+type output struct {
+	core    zapcore.Core
+	closers []io.Closer
+}
+
 func init() {
-	var (
-		config     zapcore.EncoderConfig
-		stackLevel zapcore.Level
-	)
+	var stackLevel zapcore.Level
+	// 根据当前环境和日志级别（warn以上）自动打印调用栈信息
+	if etc.ServerTypeIsProd() {
+		stackLevel = zap.ErrorLevel
+	} else {
+		stackLevel = zap.WarnLevel
+	}
 	Atom = zap.NewAtomicLevel()
-	fileSync := zapcore.AddSync(&lumberjack.Logger{
-		Filename:   etc.GetLogPath(),
-		MaxSize:    500, // MB
-		MaxBackups: 3,
-		MaxAge:     7, // days
-		LocalTime:  true,
-		Compress:   true,
-	})
-	consoleSync := zapcore.AddSync(os.Stdout)
+	if err := Reload(); err != nil {
+		panic(err)
+	}
+	Logger = zap.New(&swapCore{LevelEnabler: Atom}, zap.AddCaller(), zap.AddStacktrace(stackLevel))
+	defer Logger.Sync() // flushes buffer, if any
+	Sugar = Logger.Sugar()
+	// 事件日志的级别由enabled按模块判断
+	eventLogger = zap.New(&swapCore{LevelEnabler: zapcore.DebugLevel}, zap.AddCaller(), zap.AddCallerSkip(1), zap.AddStacktrace(stackLevel))
+}
+
+// 按etc.Get().Log重新创建日志输出并设置级别, 配置有误时返回错误并保留原来的输出
+func Reload() error {
+	cfg := etc.Get().Log
+	level, err := parseLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+	levels := make(map[string]zapcore.Level)
+	for module, l := range cfg.Modules {
+		if levels[module], err = parseLevel(l); err != nil {
+			return err
+		}
+	}
+	out, err := newOutput()
+	if err != nil {
+		return err
+	}
+	old, _ := outputs.Load().(*output)
+	outputs.Store(out)
+	moduleLevels.Store(levels)
+	Atom.SetLevel(level)
+	if old != nil {
+		old.core.Sync()
+		for _, c := range old.closers {
+			c.Close()
+		}
+	}
+	return nil
+}
+
+// 级别为空时按运行环境决定
+func parseLevel(text string) (zapcore.Level, error) {
+	if text == "" {
+		if etc.ServerTypeIsProd() {
+			return zap.InfoLevel, nil
+		}
+		return zap.DebugLevel, nil
+	}
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(text)); err != nil {
+		return level, fmt.Errorf("非法的配置项：日志级别 %s", text)
+	}
+	return level, nil
+}
+
+func newEncoder() (zapcore.Encoder, error) {
+	var config zapcore.EncoderConfig
 	// 默认开发者Encoder，包含函数调用信息
 	// 可以根据环境变量调整
-	// 根据当前环境和日志级别（warn以上）自动打印调用栈信息
 	if etc.ServerTypeIsProd() {
 		config = zap.NewProductionEncoderConfig()
-		stackLevel = zap.ErrorLevel
-		Atom.SetLevel(zap.InfoLevel)
 	} else {
 		config = zap.NewDevelopmentEncoderConfig()
-		stackLevel = zap.WarnLevel
-		Atom.SetLevel(zap.DebugLevel)
 	}
 	config.EncodeTime = zapcore.ISO8601TimeEncoder
-	core := zapcore.NewCore(
-		zapcore.NewJSONEncoder(config),
-		zapcore.NewMultiWriteSyncer(consoleSync, fileSync),
-		Atom, //debug,info,warn,error
-	)
+	switch strings.ToLower(etc.Get().Log.Encoder) {
+	case "", EncoderJson:
+		return zapcore.NewJSONEncoder(config), nil
+	case EncoderConsole:
+		return zapcore.NewConsoleEncoder(config), nil
+	case EncoderLogfmt:
+		return newLogfmtEncoder(), nil
+	}
+	return nil, fmt.Errorf("非法的配置项：日志格式 %s", etc.Get().Log.Encoder)
+}
 
-	Logger = zap.New(core, zap.AddCaller(), zap.AddStacktrace(stackLevel))
-	defer Logger.Sync() // flushes buffer, if any
-	Sugar = Logger.Sugar()
+func newOutput() (*output, error) {
+	cfg := etc.Get().Log
+	enc, err := newEncoder()
+	if err != nil {
+		return nil, err
+	}
+	names := cfg.Outputs
+	if len(names) == 0 {
+		names = []string{OutputStdout, OutputFile}
+	}
+	out := &output{}
+	cores := make([]zapcore.Core, 0, len(names))
+	for _, name := range names {
+		switch strings.ToLower(name) {
+		case OutputStdout:
+			// 由systemd启动时标准输出接入了journal, 改用原生协议以保留级别, 连接失败时仍写标准输出
+			if underJournal() && !hasOutput(names, OutputJournald) {
+				if core, closer, err := newJournaldCore(enc.Clone()); err == nil {
+					out.closers = append(out.closers, closer)
+					cores = append(cores, core)
+					continue
+				}
+			}
+			cores = append(cores, zapcore.NewCore(enc.Clone(), zapcore.AddSync(os.Stdout), zapcore.DebugLevel))
+		case OutputFile:
+			w := &lumberjack.Logger{
+				Filename:   etc.GetLogPath(),
+				MaxSize:    cfg.Rotate.MaxSize, // MB
+				MaxBackups: cfg.Rotate.MaxBackups,
+				MaxAge:     cfg.Rotate.MaxAge, // days
+				LocalTime:  true,
+				Compress:   cfg.Rotate.Compress,
+			}
+			out.closers = append(out.closers, w)
+			cores = append(cores, zapcore.NewCore(enc.Clone(), zapcore.AddSync(w), zapcore.DebugLevel))
+		case OutputSyslog:
+			core, closer, err := newSyslogCore(enc.Clone())
+			if err != nil {
+				out.close()
+				return nil, err
+			}
+			out.closers = append(out.closers, closer)
+			cores = append(cores, core)
+		case OutputJournald:
+			core, closer, err := newJournaldCore(enc.Clone())
+			if err != nil {
+				out.close()
+				return nil, err
+			}
+			out.closers = append(out.closers, closer)
+			cores = append(cores, core)
+		default:
+			out.close()
+			return nil, fmt.Errorf("非法的配置项：日志输出 %s", name)
+		}
+	}
+	out.core = zapcore.NewTee(cores...)
+	return out, nil
+}
+
+// 标准输出是否连接到journal, systemd会设置JOURNAL_STREAM为其设备号和inode
+func underJournal() bool {
+	stream := os.Getenv("JOURNAL_STREAM")
+	if stream == "" {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+	return stream == fileDevIno(info)
+}
+
+func hasOutput(names []string, name string) bool {
+	for _, n := range names {
+		if strings.ToLower(n) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *output) close() {
+	for _, c := range o.closers {
+		c.Close()
+	}
+}
+
+// 事件所属模块配置了级别时按模块级别判断, 否则按全局级别
+func enabled(event string, level zapcore.Level) bool {
+	levels, _ := moduleLevels.Load().(map[string]zapcore.Level)
+	if l, ok := levels[strings.SplitN(event, ".", 2)[0]]; ok {
+		return l.Enabled(level)
+	}
+	return Atom.Enabled(level)
+}
+
+// 把写入转发给当前生效的输出, 使Reload对已创建的Logger同样生效
+type swapCore struct {
+	zapcore.LevelEnabler
+	fields []zapcore.Field
+}
+
+func (c *swapCore) With(fields []zapcore.Field) zapcore.Core {
+	return &swapCore{
+		LevelEnabler: c.LevelEnabler,
+		fields:       append(append([]zapcore.Field{}, c.fields...), fields...),
+	}
+}
+
+func (c *swapCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *swapCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if len(c.fields) > 0 {
+		fields = append(append([]zapcore.Field{}, c.fields...), fields...)
+	}
+	return outputs.Load().(*output).core.Write(ent, fields)
+}
+
+func (c *swapCore) Sync() error {
+	return outputs.Load().(*output).core.Sync()
 }
@@ -0,0 +1,271 @@
+package core
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sanguohot/dcm-timer/pkg/common/log"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 检查在看板上的状态
+const (
+	ExamPending     = "pending"
+	ExamCopying     = "copying"
+	ExamCopied      = "copied"
+	ExamFailed      = "failed"
+	ExamQuarantined = "quarantined"
+)
+
+var (
+	activity = &Activity{exams: make(map[string]*ExamActivity), workers: make(map[int]string)}
+	// 最多保留的检查数, 超过时丢弃最久没有变化的已结束检查
+	activityMaxExams = 500
+	// 吞吐量按分钟统计, 保留最近一小时
+	throughputBuckets = 60
+)
+
+// 一个检查最近的拷贝情况, Key为Prep_*.dat的路径
+type ExamActivity struct {
+	Key      string     `json:"key"`
+	Exam     string     `json:"exam"`
+	Status   string     `json:"status"`
+	Detail   string     `json:"detail,omitempty"`
+	Worker   int        `json:"worker,omitempty"`
+	File     string     `json:"file,omitempty"`
+	Size     int64      `json:"size"`
+	Copied   int64      `json:"copied"`
+	Failures int        `json:"failures,omitempty"`
+	Started  *time.Time `json:"started,omitempty"`
+	Updated  time.Time  `json:"updated"`
+}
+
+type WorkerActivity struct {
+	Worker  int        `json:"worker"`
+	Exam    string     `json:"exam"`
+	File    string     `json:"file,omitempty"`
+	Copied  int64      `json:"copied"`
+	Started *time.Time `json:"started,omitempty"`
+}
+
+// 一分钟内拷贝的字节数和文件数
+type Throughput struct {
+	Minute time.Time `json:"minute"`
+	Bytes  int64     `json:"bytes"`
+	Files  int       `json:"files"`
+}
+
+// 记录检查和拷贝者的实时状态, 只保存在内存中, 供管理接口和看板使用
+type Activity struct {
+	lock  sync.Mutex
+	exams map[string]*ExamActivity
+	// 拷贝者编号 => 正在拷贝的检查
+	workers    map[int]string
+	throughput []Throughput
+}
+
+func (a *Activity) update(k string, f func(e *ExamActivity)) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	e, ok := a.exams[k]
+	if !ok {
+		e = &ExamActivity{Key: k, Exam: getSplitName(filepath.Base(k))}
+		a.exams[k] = e
+	}
+	f(e)
+	e.Updated = time.Now()
+	if len(a.exams) > activityMaxExams {
+		a.evict()
+	}
+}
+
+// 丢弃最久没有变化的已结束检查
+func (a *Activity) evict() {
+	var oldest *ExamActivity
+	for _, e := range a.exams {
+		if e.Status == ExamCopying || e.Status == ExamPending {
+			continue
+		}
+		if oldest == nil || e.Updated.Before(oldest.Updated) {
+			oldest = e
+		}
+	}
+	if oldest != nil {
+		delete(a.exams, oldest.Key)
+	}
+}
+
+func (a *Activity) Pending(k string, size int64, detail string) {
+	a.update(k, func(e *ExamActivity) {
+		// 推迟的检查保留失败记录, 供看板显示
+		if e.Status == ExamQuarantined {
+			return
+		}
+		e.Status, e.Detail, e.Size = ExamPending, detail, size
+		e.Worker, e.File, e.Started, e.Copied = 0, "", nil, 0
+	})
+}
+
+func (a *Activity) Copying(k string, id int, size int64) {
+	now := time.Now()
+	a.update(k, func(e *ExamActivity) {
+		e.Status, e.Detail, e.Worker, e.Size, e.Copied, e.Started = ExamCopying, "", id, size, 0, &now
+		a.workers[id] = k
+	})
+}
+
+// 拷贝者开始拷贝检查中的一个文件
+func (a *Activity) CopyFile(id int, file string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if e, ok := a.exams[a.workers[id]]; ok {
+		e.File, e.Updated = file, time.Now()
+	}
+}
+
+// 拷贝者拷贝完一个文件
+func (a *Activity) AddBytes(id int, n int64) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if e, ok := a.exams[a.workers[id]]; ok {
+		e.Copied += n
+		e.Updated = time.Now()
+	}
+	minute := time.Now().Truncate(time.Minute)
+	if l := len(a.throughput); l == 0 || a.throughput[l-1].Minute.Before(minute) {
+		a.throughput = append(a.throughput, Throughput{Minute: minute})
+		if len(a.throughput) > throughputBuckets {
+			a.throughput = a.throughput[len(a.throughput)-throughputBuckets:]
+		}
+	}
+	last := &a.throughput[len(a.throughput)-1]
+	last.Bytes += n
+	last.Files++
+}
+
+// 检查处理结束, status为结束时的状态, 推迟的检查回到pending
+func (a *Activity) Done(k, status, detail string, failures int) {
+	a.update(k, func(e *ExamActivity) {
+		e.Status, e.Detail, e.Failures, e.Worker, e.File = status, detail, failures, 0, ""
+	})
+}
+
+// 拷贝者处理完手上的检查
+func (a *Activity) Release(id int) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	delete(a.workers, id)
+}
+
+// 按最近变化时间倒序, status为空时返回全部
+func (a *Activity) Exams(status string) []ExamActivity {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	exams := make([]ExamActivity, 0, len(a.exams))
+	for _, e := range a.exams {
+		if status == "" || e.Status == status {
+			exams = append(exams, *e)
+		}
+	}
+	sort.Slice(exams, func(i, j int) bool {
+		return exams[i].Updated.After(exams[j].Updated)
+	})
+	return exams
+}
+
+func (a *Activity) Workers() []WorkerActivity {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	workers := make([]WorkerActivity, 0, len(a.workers))
+	for id, k := range a.workers {
+		if e, ok := a.exams[k]; ok {
+			workers = append(workers, WorkerActivity{Worker: id, Exam: e.Exam, File: e.File, Copied: e.Copied, Started: e.Started})
+		}
+	}
+	sort.Slice(workers, func(i, j int) bool {
+		return workers[i].Worker < workers[j].Worker
+	})
+	return workers
+}
+
+// 最近一小时每分钟的吞吐量, 没有拷贝的分钟补0
+func (a *Activity) Throughput() []Throughput {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	now := time.Now().Truncate(time.Minute)
+	result := make([]Throughput, throughputBuckets)
+	for i := range result {
+		result[i].Minute = now.Add(-time.Duration(throughputBuckets-1-i) * time.Minute)
+	}
+	for _, t := range a.throughput {
+		if i := throughputBuckets - 1 - int(now.Sub(t.Minute)/time.Minute); i >= 0 && i < throughputBuckets {
+			result[i] = t
+		}
+	}
+	return result
+}
+
+// 按检查名查找, 同名时取最近变化的
+func (a *Activity) Find(exam string) (ExamActivity, bool) {
+	for _, e := range a.Exams("") {
+		if e.Exam == exam || e.Key == exam {
+			return e, true
+		}
+	}
+	return ExamActivity{}, false
+}
+
+var (
+	ErrExamNotFound = errors.New("找不到检查")
+	ErrExamQueued   = errors.New("检查正在拷贝")
+)
+
+// 最近的检查, status为空时返回全部
+func GetExams(status string) []ExamActivity {
+	return activity.Exams(status)
+}
+
+func GetWorkers() []WorkerActivity {
+	return activity.Workers()
+}
+
+func GetThroughput() []Throughput {
+	return activity.Throughput()
+}
+
+// 重试失败或被放弃的检查, 清除失败记录后立即加入拷贝队列
+func RetryExam(exam string) (ExamActivity, error) {
+	e, ok := activity.Find(exam)
+	if !ok {
+		return e, ErrExamNotFound
+	}
+	failures.Reset(e.Key)
+	activity.Done(e.Key, ExamPending, "", 0)
+	return enqueueExam(e.Key, false)
+}
+
+// 重新拷贝已拷贝的检查, 目标文件不一致时覆盖
+func RecopyExam(exam string) (ExamActivity, error) {
+	e, ok := activity.Find(exam)
+	if !ok {
+		return e, ErrExamNotFound
+	}
+	return enqueueExam(e.Key, true)
+}
+
+func enqueueExam(k string, recopy bool) (ExamActivity, error) {
+	info, err := os.Stat(k)
+	if err != nil {
+		return ExamActivity{}, err
+	}
+	item := &QueueItem{Key: k, Info: info, FirstSeen: time.Now(), Starved: true, finder: NewFinder(), recopy: recopy}
+	if !pipeline.Add(item) {
+		return ExamActivity{}, ErrExamQueued
+	}
+	log.Info("copy.manual", log.Exam(getSplitName(info.Name())), log.Src(k), zap.Bool("recopy", recopy))
+	e, _ := activity.Find(k)
+	return e, nil
+}
@@ -1,11 +1,19 @@
 package core
 
 import (
+	"context"
+	"fmt"
 	"github.com/sanguohot/dcm-timer/etc"
+	"github.com/sanguohot/dcm-timer/pkg/audit"
+	"github.com/sanguohot/dcm-timer/pkg/common/file"
 	"github.com/sanguohot/dcm-timer/pkg/common/log"
+	"github.com/sanguohot/dcm-timer/pkg/notify"
+	"github.com/sanguohot/dcm-timer/pkg/stream"
 	"go.uber.org/zap"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -21,35 +29,57 @@ type Cleaner struct {
 }
 
 func NewCleaner() *Cleaner {
-	if etc.Config.HoldDays <= 0 {
-		log.Logger.Fatal("非法的配置项：保留天数", zap.Int("HoldDays", etc.Config.HoldDays))
+	if etc.Get().HoldDays <= 0 {
+		log.Fatal("clean.invalid_hold_days", zap.Int("hold_days", etc.Get().HoldDays))
 	}
-	t := time.Now().Add(-time.Duration(etc.Config.HoldDays) * 24 * time.Hour)
 	return &Cleaner{
-		Hold: time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()),
+		Hold: getHoldTime(),
 		Map:  make(map[string]os.FileInfo),
 	}
 }
 
+// 保留期限, 即etc.Get().HoldDays天前的0点
+func getHoldTime() time.Time {
+	t := time.Now().Add(-time.Duration(etc.Get().HoldDays) * 24 * time.Hour)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// 解析s20181029...形式的目录名中的日期
+func parseDirDate(name, prefix string) (time.Time, error) {
+	if len(name) < len(prefix)+8 {
+		return time.Time{}, fmt.Errorf("目录名 %s 不包含日期", name)
+	}
+	year, err := strconv.ParseInt(name[len(prefix):len(prefix)+4], 10, 32)
+	if err != nil {
+		return time.Time{}, err
+	}
+	month, err := strconv.ParseInt(name[len(prefix)+4:len(prefix)+6], 10, 32)
+	if err != nil {
+		return time.Time{}, err
+	}
+	day, err := strconv.ParseInt(name[len(prefix)+6:len(prefix)+8], 10, 32)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Date(int(year), time.Month(month), int(day), 0, 0, 0, 0, time.Now().Location()), nil
+}
+
 func (c *Cleaner) cleanerWalkFun(path string, info os.FileInfo, err error) error {
 	if info == nil {
-		log.Sugar.Infof("找不到路径 %s", path)
+		log.Info("clean.path_missing", log.Path(path))
 		return nil
 	}
+	// 内容存储由gcStore按引用数回收
+	if info.IsDir() && path == getStorePath() {
+		return filepath.SkipDir
+	}
 	if info.IsDir() && strings.HasPrefix(info.Name(), prefix) {
-		year, err := strconv.ParseInt(info.Name()[len(prefix):len(prefix)+4], 10, 32)
-		if err != nil {
-			return err
-		}
-		month, err := strconv.ParseInt(info.Name()[len(prefix)+4:len(prefix)+6], 10, 32)
-		if err != nil {
-			return err
-		}
-		day, err := strconv.ParseInt(info.Name()[len(prefix)+6:len(prefix)+8], 10, 32)
+		da, err := parseDirDate(info.Name(), prefix)
 		if err != nil {
-			return err
+			// 不是检查目录, 跳过, 不能中止整个清除
+			log.Warn("clean.dir_skipped", log.Dir(path), zap.Error(err))
+			return nil
 		}
-		da := time.Date(int(year), time.Month(month), int(day), 0, 0, 0, 0, time.Now().Location())
 		if da.Before(c.Hold) {
 			c.Map[path] = info
 		}
@@ -58,17 +88,114 @@ func (c *Cleaner) cleanerWalkFun(path string, info os.FileInfo, err error) error
 	return nil
 }
 
-func (c *Cleaner) Clean() {
-	log.Sugar.Infof("目录 => %s, 清除%d天(%v)前的数据", etc.GetDstPath(), etc.Config.HoldDays, c.Hold)
-	if err := filepath.Walk(etc.GetDstPath(), c.cleanerWalkFun); err != nil {
-		log.Logger.Error(err.Error())
+// 删除目标检查目录, 正在拷贝的检查不删除, 由下次清除处理; 删除成功时返回true
+func deleteExamDir(k, detail string) bool {
+	if holder, ok := examLocks.TryLock(filepath.Base(k), TaskClean); !ok {
+		log.Info("clean.busy", log.Exam(filepath.Base(k)), log.Dir(k), zap.String("holder", holder))
+		return false
+	}
+	defer examLocks.Unlock(filepath.Base(k))
+	// 删除前按清单确认目标检查完整, 不完整时仍删除, 记录在日志和审计中
+	if err := ValidateExamDir(k, false); err != nil && !os.IsNotExist(err) {
+		log.Warn("clean.incomplete", log.Exam(filepath.Base(k)), log.Dir(k), zap.Error(err))
+		detail = fmt.Sprintf("%s, 与清单不一致: %s", detail, err.Error())
+	}
+	if err := os.RemoveAll(k); err != nil {
+		log.Error("clean.delete_failed", log.Exam(filepath.Base(k)), log.Dir(k), zap.Error(err))
+		audit.Append(audit.Record{Action: audit.ActionDelete, Exam: filepath.Base(k), Dst: k,
+			Outcome: audit.OutcomeFailed, Detail: err.Error()})
+		return false
+	}
+	audit.Append(audit.Record{Action: audit.ActionDelete, Exam: filepath.Base(k), Dst: k, Detail: detail})
+	stream.Publish(stream.EventExamDeleted, filepath.Base(k), map[string]interface{}{"dst": k})
+	log.Info("clean.deleted", log.Exam(filepath.Base(k)), log.Dir(k))
+	return true
+}
+
+// 目标磁盘空间不足时按检查日期从旧到新删除, 直到可用空间满足need; 不删除当天的检查, 避免刚拷贝的检查被反复删除和拷贝
+func emergencyClean(ctx context.Context, need uint64) {
+	infos, err := ioutil.ReadDir(etc.GetDstPath())
+	if err != nil {
+		log.Error("clean.walk_failed", log.Dir(etc.GetDstPath()), zap.Error(err))
+		return
+	}
+	type exam struct {
+		path string
+		date time.Time
+	}
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	var exams []exam
+	for _, info := range infos {
+		if !info.IsDir() || !strings.HasPrefix(info.Name(), prefix) {
+			continue
+		}
+		da, err := parseDirDate(info.Name(), prefix)
+		if err != nil {
+			log.Warn("clean.dir_skipped", log.Dir(filepath.Join(etc.GetDstPath(), info.Name())), zap.Error(err))
+			continue
+		}
+		if !da.Before(today) {
+			continue
+		}
+		exams = append(exams, exam{path: filepath.Join(etc.GetDstPath(), info.Name()), date: da})
+	}
+	sort.Slice(exams, func(i, j int) bool {
+		if !exams[i].date.Equal(exams[j].date) {
+			return exams[i].date.Before(exams[j].date)
+		}
+		return exams[i].path < exams[j].path
+	})
+	reserve := uint64(etc.Get().Disk.ReserveMB) * 1024 * 1024
+	deleted := 0
+	log.Warn("clean.emergency", log.Dir(etc.GetDstPath()), zap.Uint64("need", need), log.Count(len(exams)))
+	for _, e := range exams {
+		if ctx.Err() != nil {
+			return
+		}
+		if free, err := file.DiskFree(etc.GetDstPath()); err == nil && free >= reserve+need {
+			break
+		}
+		if !deleteExamDir(e.path, "目标磁盘空间不足") {
+			continue
+		}
+		deleted++
+		// 去重时删除检查目录不一定释放空间, 需要回收不再引用的内容
+		if etc.Get().Dedup.Enabled {
+			gcStore(ctx)
+		}
+	}
+	log.Info("clean.done", log.Dir(etc.GetDstPath()), log.Count(deleted))
+}
+
+func (c *Cleaner) Clean(ctx context.Context) {
+	log.Info("clean.start", log.Dir(etc.GetDstPath()), zap.Int("hold_days", etc.Get().HoldDays), zap.Time("hold", c.Hold))
+	err := filepath.Walk(etc.GetDstPath(), func(path string, info os.FileInfo, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return c.cleanerWalkFun(path, info, err)
+	})
+	if err != nil && ctx.Err() != nil {
+		return
+	} else if err != nil {
+		log.Error("clean.walk_failed", log.Dir(etc.GetDstPath()), zap.Error(err))
 	}
+	deleted := 0
 	for k, _ := range c.Map {
-		if err := os.RemoveAll(k); err != nil {
-			log.Logger.Error(err.Error())
+		if ctx.Err() != nil {
+			return
 		}
-		log.Sugar.Infof("删除目录 => %s 成功", k)
+		if deleteExamDir(k, "超过保留期限") {
+			deleted++
+		}
+	}
+	gcStore(ctx)
+	log.Info("clean.done", log.Dir(etc.GetDstPath()), log.Count(deleted))
+	if threshold := etc.Get().Notify.CleanThreshold; threshold > 0 && deleted >= threshold {
+		notify.Send(notify.EventCleanerDeleted, log.Msg("clean.done"), map[string]interface{}{
+			"dst": etc.GetDstPath(), "count": deleted, "hold": c.Hold,
+		})
 	}
-	log.Sugar.Infof("目录 => %s, 清除数据完毕, 清理数量 => %d", etc.GetDstPath(), len(c.Map))
 	return
 }
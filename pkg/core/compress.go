@@ -0,0 +1,247 @@
+package core
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/sanguohot/dcm-timer/etc"
+	"github.com/sanguohot/dcm-timer/pkg/crypt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 目标文件的压缩方式
+const (
+	CompressNone = "none"
+	CompressGzip = "gzip"
+)
+
+var (
+	compressSuffix = ".gz"
+)
+
+// 按etc.Get().Compress决定目标文件名, 需要压缩的文件加上.gz后缀
+func compressedName(dstFile string) string {
+	if strings.ToLower(etc.Get().Compress.Mode) != CompressGzip {
+		return dstFile
+	}
+	ext := strings.TrimPrefix(filepath.Ext(dstFile), ".")
+	for _, e := range etc.Get().Compress.Extensions {
+		if strings.EqualFold(strings.TrimPrefix(e, "."), ext) {
+			return dstFile + compressSuffix
+		}
+	}
+	return dstFile
+}
+
+// 目标文件名 => 原文件名和压缩、加密的后缀, 如Prep_x.dat.gz.enc => Prep_x.dat, .gz.enc
+func splitStoredName(dstFile string) (string, string) {
+	name := strings.TrimSuffix(dstFile, encryptSuffix)
+	name = strings.TrimSuffix(name, compressSuffix)
+	return name, dstFile[len(name):]
+}
+
+// 按配置决定目标文件名, 先压缩再加密
+func storedName(dstFile string) string {
+	return encryptedName(compressedName(dstFile))
+}
+
+func isCompressed(dstFile string) bool {
+	_, suffix := splitStoredName(dstFile)
+	return strings.HasPrefix(suffix, compressSuffix)
+}
+
+// 压缩或加密过, 磁盘上的内容与源文件不同
+func isEncoded(dstFile string) bool {
+	_, suffix := splitStoredName(dstFile)
+	return suffix != ""
+}
+
+// 配置的压缩级别, 超出gzip范围时使用默认级别
+func compressLevel() int {
+	level := etc.Get().Compress.Level
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression || level == gzip.NoCompression {
+		return gzip.DefaultCompression
+	}
+	return level
+}
+
+// 写入目标文件时的编码, 先压缩再加密, 不需要时返回nil
+func storedEncoder(dstFile string) (func(w io.Writer) (io.WriteCloser, error), error) {
+	if !isEncoded(dstFile) {
+		return nil, nil
+	}
+	var key []byte
+	if isEncrypted(dstFile) {
+		var err error
+		if key, err = loadKey(); err != nil {
+			return nil, err
+		}
+	}
+	compressed := isCompressed(dstFile)
+	return func(w io.Writer) (io.WriteCloser, error) {
+		var closers multiCloser
+		if key != nil {
+			ew, err := crypt.NewWriter(w, key)
+			if err != nil {
+				return nil, err
+			}
+			w, closers = ew, append(closers, ew)
+		}
+		if compressed {
+			zw, err := gzip.NewWriterLevel(w, compressLevel())
+			if err != nil {
+				return nil, err
+			}
+			w, closers = zw, append(multiCloser{zw}, closers...)
+		}
+		return writeCloser{w, closers}, nil
+	}, nil
+}
+
+// 按顺序关闭, 先关闭外层的压缩流再关闭加密流
+type multiCloser []io.Closer
+
+func (c multiCloser) Close() error {
+	for _, closer := range c {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type writeCloser struct {
+	io.Writer
+	multiCloser
+}
+
+type readCloser struct {
+	io.Reader
+	multiCloser
+}
+
+// 打开目标文件, 返回解密、解压后的内容
+func openStored(dstFile string) (io.ReadCloser, error) {
+	fp, err := os.Open(dstFile)
+	if err != nil {
+		return nil, err
+	}
+	if !isEncoded(dstFile) {
+		return fp, nil
+	}
+	var r io.Reader = fp
+	closers := multiCloser{fp}
+	if isEncrypted(dstFile) {
+		key, err := loadKey()
+		if err == nil {
+			r, err = crypt.NewReader(fp, key)
+		}
+		if err != nil {
+			fp.Close()
+			return nil, fmt.Errorf("%s: %s", dstFile, err.Error())
+		}
+	}
+	if isCompressed(dstFile) {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			fp.Close()
+			return nil, fmt.Errorf("%s 不是有效的gzip文件: %s", dstFile, err.Error())
+		}
+		r, closers = zr, append(multiCloser{zr}, closers...)
+	}
+	return readCloser{r, closers}, nil
+}
+
+// 目标文件解密、解压后的字节数和sha256, 读到结尾时同时校验gzip的crc和加密块的tag
+func hashStored(dstFile string) (int64, string, error) {
+	r, err := openStored(dstFile)
+	if err != nil {
+		return 0, "", err
+	}
+	defer r.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return n, "", fmt.Errorf("读取 %s 失败: %s", dstFile, err.Error())
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// 目标文件解密、解压后的大小, 优先取清单中的记录, 没有时解压计算
+func storedSize(dstFile string, info os.FileInfo) (int64, error) {
+	if !isEncoded(dstFile) {
+		return info.Size(), nil
+	}
+	if m, err := ReadManifest(filepath.Dir(dstFile)); err == nil {
+		for _, f := range m.Files {
+			if f.Name == filepath.Base(dstFile) && f.StoredSize == info.Size() {
+				return f.Size, nil
+			}
+		}
+	}
+	n, _, err := hashStored(dstFile)
+	return n, err
+}
+
+// 按清单把检查目录解密、解压到outDir, 同时校验解压后的sha256; outDir为空时只校验
+func ExtractExam(dir, outDir string) (*Manifest, error) {
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if outDir != "" {
+		if err := os.MkdirAll(outDir, os.ModePerm); err != nil {
+			return m, err
+		}
+	}
+	for _, f := range m.Files {
+		if err := extractFile(filepath.Join(dir, f.Name), outDir, f); err != nil {
+			return m, err
+		}
+	}
+	return m, nil
+}
+
+func extractFile(p, outDir string, f ManifestFile) error {
+	r, err := openStored(p)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	var w io.Writer = ioutil.Discard
+	target := ""
+	if outDir != "" {
+		name, _ := splitStoredName(f.Name)
+		target = filepath.Join(outDir, name)
+		fp, err := os.Create(target + partSuffix)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(target + partSuffix)
+		defer fp.Close()
+		w = fp
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, h), r)
+	if err != nil {
+		return fmt.Errorf("读取 %s 失败: %s", p, err.Error())
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); n != f.Size || sum != f.Sha256 {
+		return fmt.Errorf("%s 解压后 %d 字节 sha256 %s, 与清单中的 %d 字节 %s 不一致", p, n, sum, f.Size, f.Sha256)
+	}
+	if target == "" {
+		return nil
+	}
+	if fp, ok := w.(*os.File); ok {
+		if err := fp.Close(); err != nil {
+			return err
+		}
+	}
+	os.Chtimes(target+partSuffix, f.ModTime, f.ModTime)
+	return os.Rename(target+partSuffix, target)
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/sanguohot/dcm-timer/pkg/common/file"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 目标文件已存在时的处理策略
+const (
+	ConflictSkip              = "skip"
+	ConflictOverwriteIfNewer  = "overwrite_if_newer"
+	ConflictOverwriteIfDiffer = "overwrite_if_differs"
+	ConflictKeepBoth          = "keep_both"
+	ConflictError             = "error"
+)
+
+// 冲突处理结果
+const (
+	ConflictDecisionSkip      = "skip"
+	ConflictDecisionOverwrite = "overwrite"
+	ConflictDecisionKeepBoth  = "keep_both"
+	ConflictDecisionError     = "error"
+)
+
+var (
+	partSuffix = ".part"
+)
+
+// 根据策略决定如何处理已存在的目标文件, 返回处理结果和实际写入的目标文件
+func resolveConflict(policy, srcFile, dstFile string) (string, string, error) {
+	if policy == "" || policy == ConflictSkip {
+		return ConflictDecisionSkip, dstFile, nil
+	}
+	srcInfo, err := os.Stat(srcFile)
+	if err != nil {
+		return ConflictDecisionError, dstFile, err
+	}
+	dstInfo, err := os.Stat(dstFile)
+	if err != nil {
+		return ConflictDecisionError, dstFile, err
+	}
+	if policy == ConflictOverwriteIfNewer {
+		if srcInfo.ModTime().After(srcModTimeOf(dstFile, dstInfo)) {
+			return ConflictDecisionOverwrite, dstFile, nil
+		}
+		return ConflictDecisionSkip, dstFile, nil
+	}
+	same, err := sameContent(srcFile, srcInfo, dstFile, dstInfo)
+	if err != nil {
+		return ConflictDecisionError, dstFile, err
+	}
+	if same {
+		return ConflictDecisionSkip, dstFile, nil
+	}
+	switch policy {
+	case ConflictOverwriteIfDiffer:
+		return ConflictDecisionOverwrite, dstFile, nil
+	case ConflictKeepBoth:
+		return resolveKeepBoth(srcFile, srcInfo, dstFile)
+	case ConflictError:
+		return ConflictDecisionError, dstFile, fmt.Errorf("目标文件 %s 已存在且与 %s 不一致", dstFile, srcFile)
+	default:
+		return ConflictDecisionError, dstFile, fmt.Errorf("非法的配置项：冲突处理策略 %s", policy)
+	}
+}
+
+// 先比较大小, 大小一致再比较sha256, 压缩、加密的目标文件比较还原后的内容
+func sameContent(srcFile string, srcInfo os.FileInfo, dstFile string, dstInfo os.FileInfo) (bool, error) {
+	dstSize, err := storedSize(dstFile, dstInfo)
+	if err != nil {
+		return false, err
+	}
+	if srcInfo.Size() != dstSize {
+		return false, nil
+	}
+	srcHash, err := file.HashFile(srcFile)
+	if err != nil {
+		return false, err
+	}
+	_, dstHash, err := hashStored(dstFile)
+	if err != nil {
+		return false, err
+	}
+	return srcHash == dstHash, nil
+}
+
+// 依次检查Prep_x.v2.dat, Prep_x.v3.dat..., 已有相同内容的版本时跳过, 否则写入第一个空闲的版本
+func resolveKeepBoth(srcFile string, srcInfo os.FileInfo, dstFile string) (string, string, error) {
+	for v := 2; ; v++ {
+		target := versionedName(dstFile, v)
+		targetInfo, err := os.Stat(target)
+		if os.IsNotExist(err) {
+			return ConflictDecisionKeepBoth, target, nil
+		}
+		if err != nil {
+			return ConflictDecisionError, target, err
+		}
+		same, err := sameContent(srcFile, srcInfo, target, targetInfo)
+		if err != nil {
+			return ConflictDecisionError, target, err
+		}
+		if same {
+			return ConflictDecisionSkip, target, nil
+		}
+	}
+}
+
+// Prep_x.dat => Prep_x.v2.dat, Prep_x.dat.gz => Prep_x.v2.dat.gz
+func versionedName(dstFile string, v int) string {
+	dstFile, suffix := splitStoredName(dstFile)
+	ext := filepath.Ext(dstFile)
+	return fmt.Sprintf("%s.v%d%s%s", strings.TrimSuffix(dstFile, ext), v, ext, suffix)
+}
+
+// 拷贝并保留源文件的修改时间, 先写临时文件再替换, 避免磁盘写满或拷贝失败时留下不完整的文件或损坏已有文件
+func copyFile(ctx context.Context, srcFile, dstFile string) (int64, string, error) {
+	target := dstFile + partSuffix
+	// 临时文件名不带.gz、.enc后缀, 按目标文件名决定是否压缩、加密
+	encode, err := storedEncoder(dstFile)
+	if err != nil {
+		return 0, "", err
+	}
+	size, hash, err := file.EncodeCopyWithHash(ctx, srcFile, target, encode)
+	if err != nil {
+		os.Remove(target)
+		return size, hash, err
+	}
+	if srcInfo, err := os.Stat(srcFile); err == nil {
+		os.Chtimes(target, srcInfo.ModTime(), srcInfo.ModTime())
+	}
+	if err := os.Rename(target, dstFile); err != nil {
+		os.Remove(target)
+		return size, hash, err
+	}
+	return size, hash, nil
+}
@@ -0,0 +1,155 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/sanguohot/dcm-timer/etc"
+	"github.com/sanguohot/dcm-timer/pkg/common/file"
+	"github.com/sanguohot/dcm-timer/pkg/common/log"
+	"go.uber.org/zap"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// 内容存储目录, 在目标目录下, 与检查目录在同一文件系统才能建立硬链接
+	storeDirName = ".store"
+	linkSuffix   = ".link"
+)
+
+func getStorePath() string {
+	return path.Join(etc.GetDstPath(), storeDirName)
+}
+
+// 按etc.Get().Dedup判断是否需要去重, 扩展名按压缩前的文件名判断
+func dedupEnabled(dstFile string) bool {
+	if !etc.Get().Dedup.Enabled {
+		return false
+	}
+	name, _ := splitStoredName(dstFile)
+	ext := strings.TrimPrefix(filepath.Ext(name), ".")
+	for _, e := range etc.Get().Dedup.Extensions {
+		if strings.EqualFold(strings.TrimPrefix(e, "."), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// 内容在存储中的路径, 如.store/ab/abcdef..., 压缩、加密的文件分开存放, 加密的按密钥分开存放
+func blobPath(dstFile, hash string) (string, error) {
+	name := hash
+	if isEncrypted(dstFile) {
+		id, err := readKeyId(dstFile)
+		if err != nil {
+			return "", err
+		}
+		name += "." + id
+	}
+	_, suffix := splitStoredName(dstFile)
+	return filepath.Join(getStorePath(), hash[:2], name+suffix), nil
+}
+
+// 把刚写入的目标文件放入内容存储, 已有相同内容时改为指向已有内容的硬链接, 否则登记为新内容
+// 硬链接数即引用数, 返回节省的字节数
+func dedupFile(dstFile, hash string) (int64, error) {
+	if len(hash) < 2 || !dedupEnabled(dstFile) {
+		return 0, nil
+	}
+	blob, err := blobPath(dstFile, hash)
+	if err != nil {
+		return 0, err
+	}
+	if err := file.EnsureDir(filepath.Dir(blob)); err != nil {
+		return 0, err
+	}
+	for i := 0; i < 2; i++ {
+		err := os.Link(dstFile, blob)
+		if err == nil {
+			return 0, nil
+		}
+		if !os.IsExist(err) {
+			return 0, err
+		}
+		saved, err := linkBlob(blob, dstFile)
+		// 清除任务恰好回收了该内容时重新登记
+		if os.IsNotExist(err) {
+			continue
+		}
+		return saved, err
+	}
+	return 0, nil
+}
+
+// 用指向blob的硬链接替换dstFile, 先链接到临时文件再改名, 失败时dstFile不变
+func linkBlob(blob, dstFile string) (int64, error) {
+	blobInfo, err := os.Stat(blob)
+	if err != nil {
+		return 0, err
+	}
+	dstInfo, err := os.Stat(dstFile)
+	if err != nil {
+		return 0, err
+	}
+	if os.SameFile(blobInfo, dstInfo) {
+		return 0, nil
+	}
+	// 压缩级别不同时压缩后的大小可能不同, 未压缩的大小不同说明存储中的内容已损坏
+	if !isEncoded(dstFile) && blobInfo.Size() != dstInfo.Size() {
+		return 0, fmt.Errorf("内容存储 %s 大小 %d 与 %s 的 %d 不一致", blob, blobInfo.Size(), dstFile, dstInfo.Size())
+	}
+	tmp := dstFile + linkSuffix
+	os.Remove(tmp)
+	if err := os.Link(blob, tmp); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, dstFile); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return dstInfo.Size(), nil
+}
+
+// 回收不再被任何检查引用的内容, 即硬链接数为1的文件
+func gcStore(ctx context.Context) {
+	root := getStorePath()
+	if !file.FilePathExist(root) {
+		return
+	}
+	count := 0
+	var size int64
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		n, err := file.LinkCount(p)
+		if err != nil {
+			return err
+		}
+		if n > 1 {
+			return nil
+		}
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+		count++
+		size += info.Size()
+		return nil
+	})
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		log.Error("clean.store_failed", log.Dir(root), zap.Error(err))
+		return
+	}
+	log.Info("clean.store_gc", log.Dir(root), log.Count(count), log.Size(size))
+}
@@ -0,0 +1,147 @@
+package core
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/sanguohot/dcm-timer/etc"
+	"github.com/sanguohot/dcm-timer/pkg/common/file"
+	"github.com/sanguohot/dcm-timer/pkg/common/log"
+	"github.com/sanguohot/dcm-timer/pkg/notify"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	// 目标磁盘空间不足, 检查推迟到下次检索
+	ErrDiskFull = errors.New("目标磁盘空间不足, 推迟拷贝")
+	diskGuard   = &DiskGuard{}
+)
+
+// 拷贝前检查目标磁盘空间, 并为正在拷贝的检查预留空间, 避免多个拷贝者同时写满磁盘
+type DiskGuard struct {
+	lock     sync.Mutex
+	reserved uint64
+	// 空间不足的告警只打印一次, 空间恢复后重置
+	alerted bool
+}
+
+// 为一个检查预留size字节, 空间不足时返回ErrDiskFull
+func (g *DiskGuard) Acquire(size uint64) error {
+	if err := file.EnsureDir(etc.GetDstPath()); err != nil {
+		return err
+	}
+	free, err := file.DiskFree(etc.GetDstPath())
+	if err != nil {
+		return err
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	reserve := uint64(etc.Get().Disk.ReserveMB) * 1024 * 1024
+	if free < g.reserved+reserve+size {
+		if !g.alerted {
+			g.alerted = true
+			log.Error("disk.low", log.Dir(etc.GetDstPath()),
+				zap.Uint64("free", free), zap.Uint64("reserved", g.reserved), zap.Uint64("reserve", reserve), zap.Uint64("need", size))
+			notify.Send(notify.EventDiskLow, log.Msg("disk.low"), map[string]interface{}{
+				"dst": etc.GetDstPath(), "free": free, "reserve": reserve, "need": size,
+			})
+			if etc.Get().Disk.EmergencyClean {
+				need := g.reserved + size
+				go exeTaskAndCalcTime(rootCtx, TaskEmergencyClean, func(ctx context.Context) {
+					emergencyClean(ctx, need)
+				})
+			}
+		}
+		return ErrDiskFull
+	}
+	if g.alerted {
+		g.alerted = false
+		log.Info("disk.recovered", log.Dir(etc.GetDstPath()), zap.Uint64("free", free))
+	}
+	g.reserved += size
+	return nil
+}
+
+func (g *DiskGuard) Release(size uint64) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.reserved -= size
+}
+
+// 检查需要写入的总大小, 目标已存在且按冲突策略不会重写的文件不计算
+func sumCopySize(items []map[string]string, policy string) uint64 {
+	var size uint64
+	for _, item := range items {
+		info, err := os.Stat(item["src"])
+		if err != nil {
+			continue
+		}
+		if mayRewrite(policy, item["src"], info, item["dst"]) {
+			size += uint64(info.Size())
+		}
+	}
+	return size
+}
+
+// 不读取文件内容判断目标文件是否可能被重写, 按内容比较的策略在大小一致时视为相同
+func mayRewrite(policy, srcFile string, srcInfo os.FileInfo, dstFile string) bool {
+	dstInfo, err := os.Stat(dstFile)
+	if err != nil {
+		return true
+	}
+	switch policy {
+	case "", ConflictSkip:
+		return false
+	case ConflictOverwriteIfNewer:
+		return srcInfo.ModTime().After(srcModTimeOf(dstFile, dstInfo))
+	}
+	if !isEncoded(dstFile) {
+		return srcInfo.Size() != dstInfo.Size()
+	}
+	if m, err := ReadManifest(filepath.Dir(dstFile)); err == nil {
+		for _, f := range m.Files {
+			if f.Name == filepath.Base(dstFile) && f.StoredSize == dstInfo.Size() {
+				return f.Size != srcInfo.Size()
+			}
+		}
+	}
+	return true
+}
+
+// 目标目录所在磁盘的使用情况
+type DiskUsage struct {
+	Path     string `json:"path"`
+	Total    uint64 `json:"total"`
+	Free     uint64 `json:"free"`
+	Reserve  uint64 `json:"reserve"`
+	Reserved uint64 `json:"reserved"`
+	// 目标目录下的检查数
+	Exams int `json:"exams"`
+}
+
+func GetDiskUsage() (DiskUsage, error) {
+	usage := DiskUsage{Path: etc.GetDstPath(), Reserve: uint64(etc.Get().Disk.ReserveMB) * 1024 * 1024}
+	total, free, err := file.DiskUsage(usage.Path)
+	if err != nil {
+		return usage, err
+	}
+	usage.Total, usage.Free = total, free
+	diskGuard.lock.Lock()
+	usage.Reserved = diskGuard.reserved
+	diskGuard.lock.Unlock()
+	infos, err := ioutil.ReadDir(usage.Path)
+	if err != nil {
+		return usage, err
+	}
+	// 只统计检查目录, 内容存储等其他目录不计入
+	for _, info := range infos {
+		if info.IsDir() && strings.HasPrefix(info.Name(), prefix) {
+			usage.Exams++
+		}
+	}
+	return usage, nil
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"github.com/sanguohot/dcm-timer/etc"
+	"github.com/sanguohot/dcm-timer/pkg/crypt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	encryptSuffix = ".enc"
+)
+
+// 按etc.Get().Encrypt决定目标文件名, 加密的文件加上.enc后缀
+func encryptedName(dstFile string) string {
+	if !etc.Get().Encrypt.Enabled {
+		return dstFile
+	}
+	return dstFile + encryptSuffix
+}
+
+func isEncrypted(dstFile string) bool {
+	return strings.HasSuffix(dstFile, encryptSuffix)
+}
+
+// 每次使用时读取, 更换密钥文件后不需要重启
+func loadKey() ([]byte, error) {
+	return crypt.LoadKey(etc.GetKeyFilePath(), etc.Get().Encrypt.KeyEnv)
+}
+
+// 加密文件使用的密钥编号
+func readKeyId(dstFile string) (string, error) {
+	fp, err := os.Open(dstFile)
+	if err != nil {
+		return "", err
+	}
+	defer fp.Close()
+	return crypt.ReadKeyId(fp)
+}
+
+// 解密、解压单个目标文件到outDir, 返回写出的文件; 加密块的tag保证内容未被篡改
+func ExtractFile(p, outDir string) (string, error) {
+	r, err := openStored(p)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	name, _ := splitStoredName(p)
+	if err := os.MkdirAll(outDir, os.ModePerm); err != nil {
+		return "", err
+	}
+	target := filepath.Join(outDir, filepath.Base(name))
+	fp, err := os.Create(target + partSuffix)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(target + partSuffix)
+	if _, err := io.Copy(fp, r); err != nil {
+		fp.Close()
+		return "", err
+	}
+	if err := fp.Close(); err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(p); err == nil {
+		os.Chtimes(target+partSuffix, info.ModTime(), info.ModTime())
+	}
+	return target, os.Rename(target+partSuffix, target)
+}
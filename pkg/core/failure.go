@@ -0,0 +1,71 @@
+package core
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sanguohot/dcm-timer/etc"
+	"sync"
+	"time"
+)
+
+var (
+	// 目录仍在写入, 检查推迟到下次检索
+	ErrStillWriting = errors.New("目录持续写入, 跳过处理")
+	failures        = &FailureTracker{counts: make(map[string]int), dead: make(map[string]time.Time)}
+)
+
+// 记录每个检查连续拷贝失败的次数, 超过etc.Get().DeadLetterAfter次后不再尝试
+type FailureTracker struct {
+	lock   sync.Mutex
+	counts map[string]int
+	dead   map[string]time.Time
+}
+
+// 记录一次失败, 本次失败导致检查被放弃时返回true
+func (t *FailureTracker) Fail(k string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.counts[k]++
+	if etc.Get().DeadLetterAfter > 0 && t.counts[k] >= etc.Get().DeadLetterAfter {
+		if _, ok := t.dead[k]; !ok {
+			t.dead[k] = time.Now()
+			return true
+		}
+	}
+	return false
+}
+
+func (t *FailureTracker) Succeed(k string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.counts, k)
+	delete(t.dead, k)
+}
+
+func (t *FailureTracker) IsDead(k string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	_, ok := t.dead[k]
+	return ok
+}
+
+func (t *FailureTracker) Count(k string) int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.counts[k]
+}
+
+// 重新尝试被放弃的检查
+func (t *FailureTracker) Reset(k string) {
+	t.Succeed(k)
+}
+
+// 被放弃的检查及放弃时间
+func (t *FailureTracker) DeadLetters() map[string]time.Time {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	dead := make(map[string]time.Time, len(t.dead))
+	for k, v := range t.dead {
+		dead[k] = v
+	}
+	return dead
+}
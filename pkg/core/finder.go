@@ -1,16 +1,22 @@
 package core
 
 import (
+	"context"
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/sanguohot/dcm-timer/etc"
+	"github.com/sanguohot/dcm-timer/pkg/audit"
 	"github.com/sanguohot/dcm-timer/pkg/common/file"
 	"github.com/sanguohot/dcm-timer/pkg/common/log"
+	"github.com/sanguohot/dcm-timer/pkg/hook"
+	"github.com/sanguohot/dcm-timer/pkg/notify"
+	"github.com/sanguohot/dcm-timer/pkg/stream"
 	"go.uber.org/zap"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -22,17 +28,44 @@ var (
 	hdr              = "hdr"
 	PersionPrefix    = "Prep_"
 	PersionSuffix    = fmt.Sprintf(".%s", dat)
-	defaultWorkers   = etc.Config.MaxWorker
 	layout           = "2006-01-02 15:04:05"
 	rawDataRecordXml = "RawdataRecord.xml"
 )
 
+// 候选文件被丢弃的原因
+const (
+	DiscardHdrMissing    = "hdr_missing"
+	DiscardXmlMissing    = "xml_missing"
+	DiscardSplitConflict = "split_conflict"
+)
+
+// 被丢弃的候选文件及原因, Detail为缺失的文件名或冲突时保留的dat
+type Discarded struct {
+	Path   string
+	Reason string
+	Detail string
+}
+
 type Finder struct {
+	// 以Prep_*.dat的路径为键, 同一P目录下的多个dat分别拷贝
 	PersionMap map[string]os.FileInfo
+	// splitName对应的dat路径, 用于发现拷贝到同一目标目录的冲突
+	SplitMap  map[string]string
+	Discarded []Discarded
+	// 已拷贝且超过延时, 等待删除的源文件
+	DeleteMap map[string]*CopiedMarker
+	// 本次检索的起始时间, 每次检索只计算一次
+	Since time.Time
+	// 包含已拷贝过的检查, 核对时使用
+	IncludeCopied bool
 }
 
 func NewFinder() *Finder {
-	return &Finder{PersionMap: make(map[string]os.FileInfo)}
+	return &Finder{
+		PersionMap: make(map[string]os.FileInfo),
+		SplitMap:   make(map[string]string),
+		DeleteMap:  make(map[string]*CopiedMarker),
+	}
 }
 
 func (f *Finder) GetSplitBySystem() string {
@@ -50,143 +83,234 @@ func (f *Finder) GetDcmTypeFilterLeftBySystemSplit(filter string) string {
 	return fmt.Sprintf("%s%s", f.GetSplitBySystem(), filter)
 }
 
+// Prep_s2018102922221914708.dat => s2018102922221914708
+func getSplitName(name string) string {
+	return name[len(PersionPrefix):strings.LastIndex(name, PersionSuffix)]
+}
+
+// P目录的上级目录, M目录与P目录同级
+func (f *Finder) getDirWithoutP(parentDir string) string {
+	return parentDir[:strings.LastIndex(parentDir, f.GetDcmTypeFilterLeftBySystemSplit("P"))]
+}
+
+// M目录下的检查信息xml
+func (f *Finder) getExamXml(parentDir, splitName string) string {
+	return path.Join(f.getDirWithoutP(parentDir), "M", splitName, fmt.Sprintf("%s.%s", splitName, xml))
+}
+
 func (f *Finder) finderWalkFunc(srcPath string, info os.FileInfo, err error) error {
 	if info == nil {
-		log.Sugar.Infof("找不到路径 %s", srcPath)
+		log.Info("scan.path_missing", log.Path(srcPath))
 		return nil
 	}
 
 	if info.IsDir() {
-		return nil
+		return f.pruneDir(srcPath, info)
 	} else {
 		if !strings.Contains(srcPath, f.GetDcmTypeFilterBySystemSplit("P")) {
 			return nil
 		}
-		since, err := time.ParseInLocation(layout, etc.Config.Since, time.Local)
-		if err != nil {
-			return err
-		}
-		t := time.Now().Add(-time.Duration(etc.Config.HoldDays) * 24 * time.Hour)
-		hold := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
-		if since.After(hold) {
-			since = hold
-		}
-		if info.ModTime().Before(since) {
+		if info.ModTime().Before(f.Since) {
 			return nil
 		}
 		// 不是Prep_s2018102922221914708.dat形式的文件跳过
 		if !strings.HasPrefix(info.Name(), PersionPrefix) || !strings.HasSuffix(info.Name(), PersionSuffix) {
 			return nil
 		}
+		// 已拷贝且拷贝后没有修改过的跳过
+		if !f.IncludeCopied && f.checkCopied(srcPath, info) {
+			return nil
+		}
+		// 连续拷贝失败被放弃的跳过
+		if failures.IsDead(srcPath) {
+			log.Debug("scan.dead_letter_skip", log.Path(srcPath))
+			return nil
+		}
 		parentDir := srcPath[:strings.LastIndex(srcPath, f.GetSplitBySystem())]
-		splitName := info.Name()[len(PersionPrefix):strings.LastIndex(info.Name(), PersionSuffix)]
-		dirWithoutP := parentDir[:strings.LastIndex(parentDir, f.GetDcmTypeFilterLeftBySystemSplit("P"))]
+		splitName := getSplitName(info.Name())
+		dirWithoutP := f.getDirWithoutP(parentDir)
 		// P目录下的对应hdr文件不存在跳过
-		if !file.IsFileExist(parentDir, fmt.Sprintf("%s%s.%s", PersionPrefix, splitName, hdr)) {
-			log.Sugar.Warnf("%s不存在, 跳过", fmt.Sprintf("%s%s.%s", PersionPrefix, splitName, hdr))
+		hdrName := fmt.Sprintf("%s%s.%s", PersionPrefix, splitName, hdr)
+		if !file.IsFileExist(parentDir, hdrName) {
+			f.discard(srcPath, DiscardHdrMissing, hdrName)
 			return nil
 		}
 		// M目录下的对应xml文件不存在跳过
-		if !file.IsFileExist(path.Join(dirWithoutP, "M", splitName), fmt.Sprintf("%s.%s", splitName, xml)) {
-			log.Sugar.Warnf("%s不存在, 跳过", fmt.Sprintf("%s.%s", splitName, xml))
+		xmlName := fmt.Sprintf("%s.%s", splitName, xml)
+		if !file.IsFileExist(path.Join(dirWithoutP, "M", splitName), xmlName) {
+			f.discard(srcPath, DiscardXmlMissing, xmlName)
 			return nil
 		}
-		fileInfo, ok := f.PersionMap[parentDir]
-		if !ok {
-			f.PersionMap[parentDir] = info
-		} else if fileInfo.Size() < info.Size() {
-			f.PersionMap[parentDir] = info
+		// 不同P目录下同名的dat会拷贝到同一目标目录, 只保留较大者
+		if other, ok := f.SplitMap[splitName]; ok {
+			keep, drop := other, srcPath
+			if f.PersionMap[other].Size() < info.Size() {
+				keep, drop = srcPath, other
+				delete(f.PersionMap, other)
+				f.PersionMap[srcPath] = info
+				f.SplitMap[splitName] = srcPath
+			}
+			f.discard(drop, DiscardSplitConflict, keep)
+			return nil
 		}
+		f.PersionMap[srcPath] = info
+		f.SplitMap[splitName] = srcPath
 		return nil
 	}
 }
 
-func (f *Finder) ShowFileList() {
-	log.Sugar.Infof("检索目录 ===> %s", etc.GetSrcPath())
-	if err := filepath.Walk(etc.GetSrcPath(), f.finderWalkFunc); err != nil {
-		log.Logger.Error(err.Error())
+func (f *Finder) discard(srcPath, reason, detail string) {
+	log.Warn("scan.discard", log.Path(srcPath), zap.String("reason", reason), zap.String("detail", detail))
+	f.Discarded = append(f.Discarded, Discarded{Path: srcPath, Reason: reason, Detail: detail})
+}
+
+// 检索起始时间, 取配置的since和保留期限两者中较早者
+func getSinceTime() (time.Time, error) {
+	since, err := time.ParseInLocation(layout, etc.Get().Since, time.Local)
+	if err != nil {
+		return since, err
+	}
+	if hold := getHoldTime(); since.After(hold) {
+		since = hold
+	}
+	return since, nil
+}
+
+// 判断目录能否整体跳过, 可以跳过时返回filepath.SkipDir
+// 注意目录的修改时间只在其直接子项增删时更新, 按修改时间跳过需要在配置中显式开启
+func (f *Finder) pruneDir(srcPath string, info os.FileInfo) error {
+	rel, err := filepath.Rel(etc.GetSrcPath(), srcPath)
+	if err != nil || rel == "." {
+		return nil
+	}
+	if etc.Get().PostCopy.Action == PostCopyMove && filepath.Clean(srcPath) == filepath.Clean(etc.GetArchivePath()) {
+		return filepath.SkipDir
+	}
+	scan := etc.Get().Scan
+	if scan.MaxDepth > 0 && len(strings.Split(rel, string(filepath.Separator))) > scan.MaxDepth {
+		log.Debug("scan.prune_depth", log.Dir(srcPath), zap.Int("max_depth", scan.MaxDepth))
+		return filepath.SkipDir
 	}
+	for _, pattern := range scan.ExcludeDirs {
+		if ok, _ := filepath.Match(pattern, info.Name()); ok {
+			log.Debug("scan.prune_exclude", log.Dir(srcPath), zap.String("pattern", pattern))
+			return filepath.SkipDir
+		}
+	}
+	if scan.DateDirPrefix != "" && strings.HasPrefix(info.Name(), scan.DateDirPrefix) {
+		// 目录名中的日期整天都早于起始时间, 不可能包含需要拷贝的文件
+		if da, err := parseDirDate(info.Name(), scan.DateDirPrefix); err == nil && !da.AddDate(0, 0, 1).After(f.Since) {
+			log.Debug("scan.prune_date", log.Dir(srcPath), zap.Time("since", f.Since))
+			return filepath.SkipDir
+		}
+	}
+	if scan.PruneByDirMtime && info.ModTime().Before(f.Since) {
+		log.Debug("scan.prune_mtime", log.Dir(srcPath), zap.Time("mtime", info.ModTime()), zap.Time("since", f.Since))
+		return filepath.SkipDir
+	}
+	return nil
+}
+
+func (f *Finder) ShowFileList(ctx context.Context) {
+	since, err := getSinceTime()
+	if err != nil {
+		log.Error("scan.since_invalid", zap.Error(err), zap.String("since", etc.Get().Since))
+		return
+	}
+	f.Since = since
+	log.Info("scan.start", log.Dir(etc.GetSrcPath()), zap.Time("since", f.Since))
+	err = filepath.Walk(etc.GetSrcPath(), func(srcPath string, info os.FileInfo, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return f.finderWalkFunc(srcPath, info, err)
+	})
+	if err != nil && err == ctx.Err() {
+		log.Info("scan.cancelled", log.Dir(etc.GetSrcPath()), zap.Error(err))
+		return
+	} else if err != nil {
+		log.Error("scan.walk_failed", log.Dir(etc.GetSrcPath()), zap.Error(err))
+	}
+	log.Info("scan.done", log.Dir(etc.GetSrcPath()), log.Count(len(f.PersionMap)), zap.Int("discarded", len(f.Discarded)))
 	return
 }
 
-// 最大延时etc.Config.CopyWaitTime秒钟检查目录有没有变化，如果有变化即可返回，没有改变etc.Config.CopyWaitTime秒后返回false
-func (f *Finder) CheckDirIsStillWriting(k string) bool {
+// 最大延时etc.Get().CopyWaitTime秒钟检查检查自己的文件有没有变化，如果有变化即可返回，没有改变etc.Get().CopyWaitTime秒后返回false
+// 只比较检查自己的文件, 不看目录的修改时间, 拷贝后写标记、移动源文件等本程序的改动不会推迟同目录的其他检查
+// ctx结束时返回true, 由调用者检查ctx.Err()
+func (f *Finder) CheckExamIsStillWriting(ctx context.Context, dir string, files []string) bool {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
 	isWriting := make(chan bool, 1)
 	go func() {
 		now := time.Now()
 		var (
-			maxDirSize int64 = 0
-			curDirSize int64 = 0
+			maxSize int64 = -1
+			curSize int64 = 0
 		)
-		for t := range ticker.C {
-			log.Sugar.Debugf("%v 开始检查目录 %s", t, k)
-			dirInfo, err := os.Stat(k)
-			if err != nil {
-				log.Logger.Error(err.Error(), zap.String("k", k))
-				continue
-			}
-			modTime := dirInfo.ModTime()
-			log.Sugar.Debugf("目录 %s 最近修改时间 %v", k, modTime)
-			if modTime.After(now) {
-				log.Sugar.Infof("目录 %s 正在写入, 修改时间更新 %d => %d,跳过拷贝", k, now.Unix(), modTime.Unix())
-				isWriting <- true
-				break
+		for {
+			var t time.Time
+			select {
+			case <-ctx.Done():
+				return
+			case t = <-ticker.C:
 			}
-			curDirSize = 0
-			err = filepath.Walk(k, func(path string, info os.FileInfo, err error) error {
+			log.Debug("stability.check", log.Dir(dir))
+			curSize = 0
+			for _, p := range files {
+				info, err := os.Stat(p)
+				if os.IsNotExist(err) {
+					continue
+				} else if err != nil {
+					log.Error("stability.stat_failed", log.Path(p), zap.Error(err))
+					continue
+				}
 				if info.ModTime().After(now) {
-					log.Sugar.Infof("文件 %s 正在写入, 跳过拷贝", path)
-					return errors.New("FILE_WRITING")
+					log.Info("stability.file_modified", log.Dir(dir), log.Path(p))
+					isWriting <- true
+					return
 				}
-				curDirSize += info.Size()
-				return nil
-			})
-			if err != nil {
-				isWriting <- true
-				break
+				curSize += info.Size()
 			}
-			if maxDirSize == 0 {
-				maxDirSize = curDirSize
+			if maxSize == -1 {
+				maxSize = curSize
 			}
-			if maxDirSize < curDirSize {
-				log.Sugar.Infof("目录 %s 正在写入, 占用空间增大 %d => %d, 跳过拷贝", k, maxDirSize, curDirSize)
+			if maxSize < curSize {
+				log.Info("stability.dir_grown", log.Dir(dir), zap.Int64("from", maxSize), zap.Int64("to", curSize))
 				isWriting <- true
-				break
+				return
 			}
-			tenSecLater := now.Add(time.Duration(etc.Config.CopyWaitTime) * time.Second)
+			tenSecLater := now.Add(time.Duration(etc.Get().CopyWaitTime) * time.Second)
 			if tenSecLater.Before(t) {
-				log.Sugar.Debugf("%d秒内目录 %s 没有任何修改, 拟进行拷贝, %d vs %d", etc.Config.CopyWaitTime, k, tenSecLater.Unix(), t.Unix())
+				log.Debug("stability.stable", log.Dir(dir), zap.Int("wait", etc.Get().CopyWaitTime))
 				isWriting <- false
+				return
 			}
 		}
 	}()
-	result := <-isWriting
-	ticker.Stop()
-	return result
+	select {
+	case result := <-isWriting:
+		return result
+	case <-ctx.Done():
+		return true
+	}
 }
 
-func (f *Finder) CopyWorkerJob(id int, k string, v os.FileInfo) error {
-	if f.CheckDirIsStillWriting(k) {
-		return fmt.Errorf("目录 %s 持续写入, 跳过处理", k)
-	}
-	splitName := v.Name()[len(PersionPrefix):strings.LastIndex(v.Name(), PersionSuffix)]
-	dirWithoutP := k[:strings.LastIndex(k, f.GetDcmTypeFilterLeftBySystemSplit("P"))]
-	log.Sugar.Debugf("k=%s, name=%s, size=%d, splitName=%s, dirWithoutP=%s", k, v.Name(), v.Size(), splitName, dirWithoutP)
-	// dat已经拷贝跳过
+// 一个检查需要拷贝的文件, k为Prep_*.dat的路径
+func (f *Finder) GetCopyItems(k string, v os.FileInfo) []map[string]string {
+	parentDir := filepath.Dir(k)
+	splitName := getSplitName(v.Name())
+	dirWithoutP := f.getDirWithoutP(parentDir)
 	dstDir := path.Join(etc.GetDstPath(), splitName)
-	// 确保目录存在
-	if err := file.EnsureDir(dstDir); err != nil {
-		return err
-	}
-
-	srcXml := path.Join(dirWithoutP, "M", splitName, fmt.Sprintf("%s.%s", splitName, xml))
+	srcXml := f.getExamXml(parentDir, splitName)
 	dstXml := path.Join(dstDir, fmt.Sprintf("%s.%s", splitName, xml))
 	srcRawDataRecordXml := path.Join(dirWithoutP, "M", splitName, rawDataRecordXml)
 	dstRawDataRecordXml := path.Join(dstDir, rawDataRecordXml)
-	srcDat := path.Join(k, v.Name())
+	srcDat := k
 	dstDat := path.Join(dstDir, fmt.Sprintf("%s%s.%s", PersionPrefix, splitName, dat))
-	srcHdr := path.Join(k, fmt.Sprintf("%s%s.%s", PersionPrefix, splitName, hdr))
+	srcHdr := path.Join(parentDir, fmt.Sprintf("%s%s.%s", PersionPrefix, splitName, hdr))
 	dstHdr := path.Join(dstDir, fmt.Sprintf("%s%s.%s", PersionPrefix, splitName, hdr))
 	m := make([]map[string]string, 4)
 	m[0] = map[string]string{"src": srcXml, "dst": dstXml}
@@ -194,73 +318,250 @@ func (f *Finder) CopyWorkerJob(id int, k string, v os.FileInfo) error {
 	m[2] = map[string]string{"src": srcDat, "dst": dstDat}
 	m[3] = map[string]string{"src": srcHdr, "dst": dstHdr}
 	for _, item := range m {
-		if bl, err := f.copyWorkerCore(id, item["src"], item["dst"]); err != nil {
+		item["dst"] = storedName(item["dst"])
+	}
+	return m
+}
+
+// k为Prep_*.dat的路径, policy为目标文件已存在时的处理策略
+func (f *Finder) CopyWorkerJob(ctx context.Context, id int, k string, v os.FileInfo, policy string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// 检索期间进入暂停的, 剩余的检查推迟到暂停结束后
+	if pauser.Check().Paused {
+		return ErrPaused
+	}
+	// 整个检查的拷贝时间上限, 包括等待目录稳定和重试
+	if timeout := etc.Get().ExamTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+	}
+	splitName := getSplitName(v.Name())
+	// 清除任务正在删除同名目标目录时推迟
+	if holder, ok := examLocks.TryLock(splitName, TaskCopy); !ok {
+		return errors.Wrap(ErrExamBusy, holder)
+	}
+	defer examLocks.Unlock(splitName)
+	parentDir := filepath.Dir(k)
+	m := f.GetCopyItems(k, v)
+	srcs := make([]string, 0, len(m))
+	for _, item := range m {
+		srcs = append(srcs, item["src"])
+	}
+	if f.CheckExamIsStillWriting(ctx, parentDir, srcs) {
+		if err := ctx.Err(); err != nil {
 			return err
-		} else if !bl {
+		}
+		return errors.Wrap(ErrStillWriting, parentDir)
+	}
+	log.Debug("copy.job", log.Worker(id), log.Exam(splitName), log.Src(k), log.Size(v.Size()))
+	srcDat := k
+	// 拷贝前检查目标磁盘空间, 空间不足的推迟到下次检索
+	size := sumCopySize(m, policy)
+	if err := diskGuard.Acquire(size); err != nil {
+		return err
+	}
+	defer diskGuard.Release(size)
+	// dat已经拷贝跳过
+	dstDir := path.Join(etc.GetDstPath(), splitName)
+	// 确保目录存在
+	if err := file.EnsureDir(dstDir); err != nil {
+		return err
+	}
+	stream.Publish(stream.EventCopyStarted, splitName, map[string]interface{}{"worker": id, "src": k, "dst": dstDir, "size": size})
+	written := false
+	for _, item := range m {
+		dst, hash, err := f.copyWithRetry(ctx, id, item["src"], item["dst"], policy)
+		if err != nil {
 			return err
 		}
+		// 保留两者时实际写入的是带版本后缀的文件
+		item["dst"] = dst
+		if hash != "" {
+			written = true
+			item["sha256"], item["copied_at"] = hash, time.Now().Format(time.RFC3339Nano)
+			// 压缩的目标文件记录源文件大小, 写清单时不必解压; 记录源文件修改时间, 供overwrite_if_newer比较
+			if info, err := os.Stat(item["src"]); err == nil {
+				item["size"] = strconv.FormatInt(info.Size(), 10)
+				item["src_mtime"] = info.ModTime().Format(time.RFC3339Nano)
+			}
+		}
+	}
+	if err := f.afterCopy(id, srcDat, m); err != nil {
+		return err
 	}
+	previous, _ := ReadManifest(dstDir)
+	manifest, err := writeManifest(splitName, srcDat, dstDir, m)
+	if err != nil {
+		return err
+	}
+	log.Debug("copy.manifest", log.Worker(id), log.Exam(splitName), log.Path(manifest))
+	exportResearch(ctx, id, splitName, dstDir, m)
+	// 文件都已存在而跳过且清单没有变化时, 钩子已在之前的拷贝后执行成功, 没有记录或失败的重新执行
+	if current, err := ReadManifest(dstDir); !written && err == nil && previous.sameFiles(current) && !hook.Unsettled(splitName) {
+		log.Debug("hook.unchanged", log.Worker(id), log.Exam(splitName))
+		return nil
+	}
+	e := newHookExam(splitName, srcDat, dstDir, m)
+	e.Manifest = manifest
+	hook.Trigger(e)
 	return nil
 }
 
-func (f *Finder) CopyWorker(id int, jobs <-chan string, results chan<- bool) {
-	for j := range jobs {
-		if err := f.CopyWorkerJob(id, j, f.PersionMap[j]); err != nil {
-			results <- false
-			log.Logger.Error(err.Error(), zap.String("k", j))
-			continue
+// 传给拷贝后处理钩子的检查信息, 源文件不存在而没有拷贝的文件不列出
+func newHookExam(splitName, srcDat, dstDir string, items []map[string]string) hook.Exam {
+	e := hook.Exam{Exam: splitName, Src: srcDat, Dst: dstDir, CopiedAt: time.Now()}
+	for _, item := range items {
+		if info, err := os.Stat(item["dst"]); err == nil {
+			e.Files = append(e.Files, hook.File{Src: item["src"], Dst: item["dst"], Size: info.Size()})
 		}
-		results <- true
 	}
+	return e
 }
 
-func (f *Finder) copyWorkerCore(id int, srcFile, dstFile string) (bool, error) {
-	if !file.FilePathExist(srcFile) {
-		log.Sugar.Infof("拷贝者:%d %s不存在, 跳过", id, srcFile)
-		return true, nil
-	} else if file.FilePathExist(dstFile) {
-		log.Sugar.Debugf("拷贝者:%d %s已拷贝, 跳过", id, dstFile)
-		return true, nil
-	} else if size, err := file.StandardCopy(srcFile, dstFile); err != nil {
-		log.Logger.Error(err.Error(), zap.String("src", srcFile), zap.String("dst", dstFile))
-		return false, err
-	} else {
-		log.Sugar.Infof("拷贝者:%d 拷贝成功 %s ===> %s, 约 %d KB", id, srcFile, dstFile, size/1024)
-		return true, nil
+func (f *Finder) onJobError(k string, err error) {
+	// 空间不足只告警一次, 不逐个打印错误
+	exam := getSplitName(filepath.Base(k))
+	if err == ErrDiskFull {
+		log.Debug("copy.deferred_disk", log.Exam(exam), log.Src(k))
+		activity.Done(k, ExamPending, err.Error(), failures.Count(k))
+		return
+	}
+	if err == ErrPaused {
+		log.Debug("copy.deferred_paused", log.Exam(exam), log.Src(k))
+		activity.Done(k, ExamPending, err.Error(), failures.Count(k))
+		return
+	}
+	// 取消的检查不计入失败次数, 超时的计入
+	if err == context.Canceled {
+		log.Debug("copy.cancelled", log.Exam(exam), log.Src(k))
+		activity.Done(k, ExamPending, log.Msg("copy.cancelled"), failures.Count(k))
+		return
+	}
+	if errors.Cause(err) == ErrExamBusy {
+		log.Info("copy.deferred_busy", log.Exam(exam), log.Src(k), zap.Error(err))
+		activity.Done(k, ExamPending, err.Error(), failures.Count(k))
+		return
+	}
+	if errors.Cause(err) == ErrStillWriting {
+		log.Info("copy.deferred_writing", log.Exam(exam), log.Src(k))
+		activity.Done(k, ExamPending, ErrStillWriting.Error(), failures.Count(k))
+		return
 	}
+	log.Error("copy.job_failed", log.Exam(exam), log.Src(k), zap.Error(err))
+	markFailed(k)
+	fields := map[string]interface{}{"exam": exam, "src": k, "error": err.Error(), "failures": failures.Count(k) + 1}
+	notify.Send(notify.EventCopyFailed, log.Msg("copy.job_failed"), fields)
+	if failures.Fail(k) {
+		log.Error("copy.dead_letter", log.Exam(exam), log.Src(k), zap.Int("failures", failures.Count(k)))
+		audit.Append(audit.Record{Action: audit.ActionQuarantine, Exam: exam, Src: k, Detail: err.Error()})
+		notify.Send(notify.EventDeadLetter, log.Msg("copy.dead_letter"), fields)
+		stream.Publish(stream.EventCopyFailed, exam, map[string]interface{}{"src": k, "error": err.Error(), "failures": failures.Count(k), "quarantined": true})
+		activity.Done(k, ExamQuarantined, err.Error(), failures.Count(k))
+		return
+	}
+	stream.Publish(stream.EventCopyFailed, exam, map[string]interface{}{"src": k, "error": err.Error(), "failures": failures.Count(k)})
+	activity.Done(k, ExamFailed, err.Error(), failures.Count(k))
 }
 
-func (f *Finder) CopyFileToDst() {
-	if len(f.PersionMap) <= 0 {
-		log.Sugar.Info("需拷贝数 ===> 0")
-		return
+// 单个文件拷贝失败时按etc.Get().CopyRetries重试
+func (f *Finder) copyWithRetry(ctx context.Context, id int, srcFile, dstFile, policy string) (string, string, error) {
+	for i := 0; ; i++ {
+		dst, hash, err := f.copyWorkerCore(ctx, id, srcFile, dstFile, policy)
+		if err == nil || i >= etc.Get().CopyRetries {
+			return dst, hash, err
+		}
+		if ctx.Err() != nil {
+			return dst, "", ctx.Err()
+		}
+		log.Warn("copy.retry", log.Worker(id), log.Src(srcFile), zap.Int("retry", i+1), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return dst, "", ctx.Err()
+		case <-time.After(time.Duration(etc.Get().CopyRetryDelay) * time.Second):
+		}
+	}
+}
+
+// 返回实际写入的目标文件和sha256, 目标文件已存在时按policy处理, 没有写入时sha256为空
+func (f *Finder) copyWorkerCore(ctx context.Context, id int, srcFile, dstFile, policy string) (string, string, error) {
+	if !file.FilePathExist(srcFile) {
+		log.Info("copy.src_missing", log.Worker(id), log.Src(srcFile))
+		return dstFile, "", nil
 	}
-	workers := defaultWorkers
-	if len(f.PersionMap) < defaultWorkers {
-		workers = len(f.PersionMap)
-	}
-	log.Sugar.Infof("需拷贝数 ===> %d, 拷贝者数 ===> %d", len(f.PersionMap), workers)
-	jobs := make(chan string, len(f.PersionMap))
-	results := make(chan bool, len(f.PersionMap))
-	for w := 1; w <= workers; w++ {
-		go f.CopyWorker(w, jobs, results)
-	}
-	for k, _ := range f.PersionMap {
-		jobs <- k
-	}
-	close(jobs)
-	// 这里收集所有结果
-	cnt := 0
-	for a := 1; a <= len(f.PersionMap); a++ {
-		if <-results {
-			cnt++
+	exam := filepath.Base(filepath.Dir(dstFile))
+	if file.FilePathExist(dstFile) {
+		decision, target, err := resolveConflict(policy, srcFile, dstFile)
+		log.Info("copy.conflict", log.Worker(id), log.Exam(exam), log.Src(srcFile), log.Dst(target),
+			zap.String("policy", policy), zap.String("decision", decision))
+		if err != nil {
+			audit.Append(audit.Record{Action: audit.ActionOverwrite, Exam: exam, Src: srcFile, Dst: target,
+				Outcome: audit.OutcomeFailed, Detail: err.Error()})
+			return dstFile, "", err
+		}
+		if decision == ConflictDecisionSkip {
+			return target, "", nil
 		}
+		audit.Append(audit.Record{Action: audit.ActionOverwrite, Exam: exam, Src: srcFile, Dst: target, Detail: decision})
+		dstFile = target
 	}
-	//close(results)
-	log.Sugar.Infof("需拷贝数 ===> %d, 已拷贝数 ===> %d", len(f.PersionMap), cnt)
+	// 单个文件的拷贝时间上限, 超时后按失败重试
+	if timeout := etc.Get().CopyTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+	}
+	activity.CopyFile(id, srcFile)
+	size, hash, err := copyFile(withCopyProgress(ctx, id, exam, srcFile, dstFile), srcFile, dstFile)
+	if err != nil {
+		log.Error("copy.failed", log.Worker(id), log.Exam(exam), log.Src(srcFile), log.Dst(dstFile), zap.Error(err))
+		audit.Append(audit.Record{Action: audit.ActionCopy, Exam: exam, Src: srcFile, Dst: dstFile,
+			Outcome: audit.OutcomeFailed, Detail: err.Error()})
+		return dstFile, "", err
+	}
+	audit.Append(audit.Record{Action: audit.ActionCopy, Exam: exam, Src: srcFile, Dst: dstFile, Size: size, Sha256: hash})
+	activity.AddBytes(id, size)
+	// 去重失败时保留拷贝的文件, 不影响拷贝结果
+	if saved, err := dedupFile(dstFile, hash); err != nil {
+		log.Warn("copy.dedup_failed", log.Worker(id), log.Exam(exam), log.Dst(dstFile), zap.Error(err))
+	} else if saved > 0 {
+		log.Debug("copy.dedup", log.Worker(id), log.Exam(exam), log.Dst(dstFile), log.Size(saved))
+	}
+	log.Info("copy.success", log.Worker(id), log.Exam(exam), log.Src(srcFile), log.Dst(dstFile), log.Size(size))
+	return dstFile, hash, nil
 }
 
-func (f *Finder) FindAndCopy() {
-	f.ShowFileList()
-	f.CopyFileToDst()
+// 按stream.GetProgressInterval()的间隔推送单个文件的拷贝进度, 拷贝完成时推送最后一次
+func withCopyProgress(ctx context.Context, id int, exam, srcFile, dstFile string) context.Context {
+	var total int64
+	if info, err := os.Stat(srcFile); err == nil {
+		total = info.Size()
+	}
+	interval := stream.GetProgressInterval()
+	var last time.Time
+	return file.WithProgress(ctx, func(n int64) {
+		if n < total && time.Since(last) < interval {
+			return
+		}
+		last = time.Now()
+		stream.Publish(stream.EventCopyProgress, exam, map[string]interface{}{
+			"worker": id, "src": srcFile, "dst": dstFile, "bytes": n, "total": total,
+		})
+	})
+}
+
+func (f *Finder) FindAndCopy(ctx context.Context) {
+	if state := pauser.Check(); state.Paused {
+		log.Debug("copy.skipped_paused", zap.String("reason", state.Reason), zap.String("detail", state.Detail))
+		return
+	}
+	f.ShowFileList(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+	// 拷贝由流水线中的拷贝者完成, 不等待
+	pipeline.Submit(f)
+	f.DeleteCopiedSources()
 }
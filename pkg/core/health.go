@@ -0,0 +1,263 @@
+package core
+
+import (
+	"fmt"
+	"github.com/sanguohot/dcm-timer/etc"
+	"github.com/sanguohot/dcm-timer/pkg/common/file"
+	"github.com/sanguohot/dcm-timer/pkg/common/log"
+	"github.com/sanguohot/dcm-timer/pkg/crypt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// 挂载失效时文件系统调用可能一直阻塞, 单项检查超过该时间视为失败
+	healthCheckTimeout = 5 * time.Second
+	// 清除任务每天0点执行, 超过该时间没有完成视为停滞
+	cleanStaleAfter = 25 * time.Hour
+	// 等待中的定时任务循环超过该时间没有心跳, 视为循环已停止
+	heartbeatTimeout = 3 * heartbeatInterval
+)
+
+// 定时任务的运行情况
+type TaskStatus struct {
+	Task    string     `json:"task"`
+	Running *time.Time `json:"running,omitempty"`
+	Last    *time.Time `json:"last,omitempty"`
+	Next    *time.Time `json:"next,omitempty"`
+}
+
+type HealthCheck struct {
+	Name string `json:"name"`
+	OK   bool   `json:"ok"`
+	// 存活检查失败说明进程已停滞, 需要重启; 其他检查失败只说明暂时不能正常拷贝
+	Liveness bool                   `json:"liveness"`
+	Detail   string                 `json:"detail,omitempty"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+}
+
+type HealthReport struct {
+	OK     bool          `json:"ok"`
+	Time   time.Time     `json:"time"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+// 定时任务的运行、最近一次成功和下一次计划时间
+func GetTaskStatus(task string) TaskStatus {
+	taskLock.Lock()
+	defer taskLock.Unlock()
+	s := TaskStatus{Task: task}
+	if t, ok := taskRunning[task]; ok {
+		s.Running = &t
+	}
+	if t, ok := taskDone[task]; ok {
+		s.Last = &t
+	}
+	if t, ok := taskNext[task]; ok {
+		s.Next = &t
+	}
+	return s
+}
+
+// 存活检查, 只检查定时任务循环的心跳; ready为true时同时检查任务是否停滞、目录、磁盘和日志
+func CheckHealth(ready bool) HealthReport {
+	checks := checkLoops()
+	if ready {
+		checks = append(checks, checkScanStale(), checkCleanStale(), checkSource(), checkOutput(), checkDisk(), checkWorkers(), checkLogFile(), checkEncryptKey())
+	}
+	report := HealthReport{OK: true, Time: time.Now(), Checks: checks}
+	for _, c := range checks {
+		if !c.OK {
+			report.OK = false
+		}
+	}
+	return report
+}
+
+// 定时任务循环都有心跳, 供systemd看门狗使用; 检查大、拷贝慢导致的任务超时不重启进程
+func Alive() bool {
+	return CheckHealth(false).OK
+}
+
+// 每个已启动的定时任务循环一项检查, 循环等待时要求有心跳, 执行任务时不能超过etc.Get().TaskTimeout
+// 任务超时会被取消, 取消后仍没有返回(如挂起的NFS)说明循环已卡死
+func checkLoops() []HealthCheck {
+	taskLock.Lock()
+	defer taskLock.Unlock()
+	checks := []HealthCheck{}
+	limit := time.Duration(etc.Get().TaskTimeout) * time.Second
+	for _, task := range []string{TaskScan, TaskClean, TaskVerify} {
+		last, ok := heartbeats[task]
+		if !ok {
+			continue
+		}
+		started, running := taskRunning[task]
+		c := HealthCheck{Name: task + "_loop", OK: true, Liveness: true, Data: map[string]interface{}{"heartbeat": last, "running": running}}
+		if running {
+			c.Data["started"] = started
+			if elapsed := time.Since(started); limit > 0 && elapsed > limit+heartbeatTimeout {
+				c.OK = false
+				c.Detail = fmt.Sprintf("任务已运行%s, 超时取消后仍没有结束, 定时任务循环可能已卡死", elapsed.Truncate(time.Second))
+			}
+		} else if elapsed := time.Since(last); elapsed > heartbeatTimeout {
+			c.OK = false
+			c.Detail = fmt.Sprintf("%s内没有心跳, 定时任务循环可能已停止", elapsed.Truncate(time.Second))
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+// 检索在间隔的两倍(至少5分钟)内没有完成时, 认为timerTask已停滞
+func checkScanStale() HealthCheck {
+	c := HealthCheck{Name: TaskScan, OK: true}
+	interval := time.Duration(etc.Get().Interval) * time.Second
+	grace := 2 * interval
+	if grace < 5*time.Minute {
+		grace = 5 * time.Minute
+	}
+	return checkTaskStale(c, interval+grace)
+}
+
+func checkCleanStale() HealthCheck {
+	return checkTaskStale(HealthCheck{Name: TaskClean, OK: true}, cleanStaleAfter)
+}
+
+func checkTaskStale(c HealthCheck, staleAfter time.Duration) HealthCheck {
+	s := GetTaskStatus(c.Name)
+	c.Data = map[string]interface{}{"last": s.Last, "next": s.Next, "running": s.Running, "stale_after": staleAfter.Seconds()}
+	since := startedAt
+	if s.Last != nil {
+		since = *s.Last
+	}
+	if elapsed := time.Since(since); elapsed > staleAfter {
+		c.OK = false
+		c.Detail = fmt.Sprintf("%s内没有完成, 定时任务可能已停滞", elapsed.Truncate(time.Second))
+	}
+	return c
+}
+
+func checkSource() HealthCheck {
+	c := HealthCheck{Name: "source", OK: true, Data: map[string]interface{}{"path": etc.GetSrcPath()}}
+	err := withTimeout(func() error {
+		fp, err := os.Open(etc.GetSrcPath())
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		if _, err := fp.Readdirnames(1); err != nil && err != io.EOF {
+			return err
+		}
+		return nil
+	})
+	return failOn(c, err)
+}
+
+// 在目标目录创建并删除一个临时文件, 确认可写
+func checkOutput() HealthCheck {
+	c := HealthCheck{Name: "output", OK: true, Data: map[string]interface{}{"path": etc.GetDstPath()}}
+	err := withTimeout(func() error {
+		fp, err := ioutil.TempFile(etc.GetDstPath(), ".dcm-timer-healthz-")
+		if err != nil {
+			return err
+		}
+		fp.Close()
+		return os.Remove(fp.Name())
+	})
+	return failOn(c, err)
+}
+
+func checkDisk() HealthCheck {
+	c := HealthCheck{Name: "disk", OK: true, Data: map[string]interface{}{"path": etc.GetDstPath()}}
+	var free uint64
+	err := withTimeout(func() error {
+		var err error
+		free, err = file.DiskFree(etc.GetDstPath())
+		return err
+	})
+	if err != nil {
+		return failOn(c, err)
+	}
+	reserve := uint64(etc.Get().Disk.ReserveMB) * 1024 * 1024
+	c.Data["free"], c.Data["reserve"] = free, reserve
+	if free < reserve {
+		c.OK = false
+		c.Detail = "目标磁盘可用空间低于保留空间"
+	}
+	return c
+}
+
+// 拷贝者全部忙碌且仍有检查排队时视为饱和, 饱和不算失败
+func checkWorkers() HealthCheck {
+	queued, busy := pipeline.Len()
+	total := pipeline.Size()
+	copyStatus := GetTaskStatus(TaskCopy)
+	return HealthCheck{Name: "workers", OK: true, Data: map[string]interface{}{
+		"busy": busy, "total": total, "queued": queued,
+		"saturated": busy >= total && queued > 0, "last_copy": copyStatus.Last,
+	}}
+}
+
+// 配置了写文件时检查日志文件是否可写
+func checkLogFile() HealthCheck {
+	c := HealthCheck{Name: "log", OK: true, Data: map[string]interface{}{"path": etc.GetLogPath()}}
+	outputs := etc.Get().Log.Outputs
+	enabled := len(outputs) == 0
+	for _, o := range outputs {
+		if strings.ToLower(o) == log.OutputFile {
+			enabled = true
+		}
+	}
+	if !enabled {
+		c.Detail = "没有配置写日志文件"
+		return c
+	}
+	err := withTimeout(func() error {
+		fp, err := os.OpenFile(etc.GetLogPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		return fp.Close()
+	})
+	return failOn(c, err)
+}
+
+// 开启加密时检查密钥能否读取, 否则所有拷贝都会失败
+func checkEncryptKey() HealthCheck {
+	c := HealthCheck{Name: "encrypt", OK: true}
+	if !etc.Get().Encrypt.Enabled {
+		c.Detail = "没有开启加密"
+		return c
+	}
+	key, err := loadKey()
+	if err != nil {
+		return failOn(c, err)
+	}
+	c.Data = map[string]interface{}{"key_id": crypt.KeyId(key)}
+	return c
+}
+
+func failOn(c HealthCheck, err error) HealthCheck {
+	if err != nil {
+		c.OK = false
+		c.Detail = err.Error()
+	}
+	return c
+}
+
+// 超时后放弃等待, 阻塞的调用留在后台直到返回
+func withTimeout(f func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(healthCheckTimeout):
+		return fmt.Errorf("检查超过%s没有返回, 挂载可能已失效", healthCheckTimeout)
+	}
+}
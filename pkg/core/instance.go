@@ -0,0 +1,80 @@
+package core
+
+import (
+	"fmt"
+	"github.com/sanguohot/dcm-timer/etc"
+	"github.com/sanguohot/dcm-timer/pkg/common/file"
+	"github.com/sanguohot/dcm-timer/pkg/common/log"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var (
+	lockFileName = "dcm-timer.lock"
+	pidFileName  = "dcm-timer.pid"
+	// 持有实例锁的文件, 进程退出前不关闭
+	instanceLock *os.File
+)
+
+// 状态目录下的实例锁, 防止两个dcm-timer同时处理同一源目录和目标目录
+// 锁由操作系统在进程退出时释放, 崩溃后残留的PID文件会被识别并覆盖
+func AcquireInstanceLock() error {
+	dir := etc.GetStatePath()
+	if err := file.EnsureDir(dir); err != nil {
+		return err
+	}
+	fp, err := os.OpenFile(path.Join(dir, lockFileName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if err := file.LockFile(fp.Fd()); err != nil {
+		fp.Close()
+		if err != file.ErrLocked {
+			return err
+		}
+		pid, _ := readPidFile()
+		if pid > 0 && processExists(pid) {
+			return fmt.Errorf("另一个dcm-timer实例(pid %d)正在使用状态目录 %s", pid, dir)
+		}
+		return fmt.Errorf("状态目录 %s 已被其他进程锁定, PID文件中的进程 %d 不存在, 可能在其他主机或容器中运行", dir, pid)
+	}
+	if pid, err := readPidFile(); err == nil && pid != os.Getpid() {
+		// 能拿到锁说明原进程已经退出
+		log.Warn("instance.stale_pid", zap.Int("pid", pid), log.Path(pidFilePath()))
+	}
+	if err := ioutil.WriteFile(pidFilePath(), []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644); err != nil {
+		file.UnlockFile(fp.Fd())
+		fp.Close()
+		return err
+	}
+	instanceLock = fp
+	log.Info("instance.locked", zap.Int("pid", os.Getpid()), log.Dir(dir))
+	return nil
+}
+
+// 删除PID文件并释放实例锁
+func ReleaseInstanceLock() {
+	if instanceLock == nil {
+		return
+	}
+	os.Remove(pidFilePath())
+	file.UnlockFile(instanceLock.Fd())
+	instanceLock.Close()
+	instanceLock = nil
+}
+
+func pidFilePath() string {
+	return path.Join(etc.GetStatePath(), pidFileName)
+}
+
+func readPidFile() (int, error) {
+	data, err := ioutil.ReadFile(pidFilePath())
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
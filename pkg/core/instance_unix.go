@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package core
+
+import "syscall"
+
+// 发送0号信号检查进程是否存在, 没有权限说明进程存在但属于其他用户
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,35 @@
+package core
+
+import (
+	"github.com/pkg/errors"
+	"sync"
+)
+
+var (
+	// 检查正被其他任务处理, 推迟到下次检索
+	ErrExamBusy = errors.New("检查正被其他任务处理")
+	examLocks   = &ExamLocks{held: make(map[string]string)}
+)
+
+// 以检查名(目标目录名)为键的锁, 拷贝和清除同一检查时互斥, 拿不到锁的一方跳过而不等待
+type ExamLocks struct {
+	lock sync.Mutex
+	held map[string]string
+}
+
+// 加锁成功返回true, 失败时返回持有者
+func (l *ExamLocks) TryLock(exam, owner string) (string, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if holder, ok := l.held[exam]; ok {
+		return holder, false
+	}
+	l.held[exam] = owner
+	return owner, true
+}
+
+func (l *ExamLocks) Unlock(exam string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	delete(l.held, exam)
+}
@@ -0,0 +1,199 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sanguohot/dcm-timer/pkg/crypt"
+	"github.com/sanguohot/dcm-timer/pkg/version"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+var (
+	// 写在每个目标检查目录下, 列出检查的全部文件
+	ManifestName = "manifest.json"
+	host, _      = os.Hostname()
+)
+
+type ManifestFile struct {
+	// 相对检查目录的文件名
+	Name string `json:"name"`
+	Src  string `json:"src"`
+	// 压缩、加密的文件Size和Sha256为还原后的, StoredSize为磁盘上的大小
+	Size        int64     `json:"size"`
+	Compression string    `json:"compression,omitempty"`
+	Encryption  string    `json:"encryption,omitempty"`
+	KeyId       string    `json:"key_id,omitempty"`
+	StoredSize  int64     `json:"stored_size,omitempty"`
+	ModTime     time.Time `json:"mtime"`
+	// 源文件的修改时间, 去重的目标文件与其他检查共用inode, ModTime不一定是本检查源文件的
+	SrcModTime time.Time `json:"src_mtime"`
+	Sha256     string    `json:"sha256"`
+	CopiedAt   time.Time `json:"copied_at"`
+}
+
+// 磁盘上应有的大小
+func (f ManifestFile) storedSize() int64 {
+	if f.Compression != "" || f.Encryption != "" {
+		return f.StoredSize
+	}
+	return f.Size
+}
+
+// 目标检查目录的清单, 下游和清除任务不访问源目录即可判断检查是否完整
+type Manifest struct {
+	Exam      string         `json:"exam"`
+	Src       string         `json:"src"`
+	Version   string         `json:"version"`
+	Host      string         `json:"host"`
+	CreatedAt time.Time      `json:"created_at"`
+	Files     []ManifestFile `json:"files"`
+}
+
+// 写入检查的清单, 本次没有写入的文件(目标已存在被跳过)沿用上一份清单中一致的记录, 否则重新计算sha256
+// 先写临时文件再改名, 下游读到清单时检查已完整
+func writeManifest(exam, srcDat, dstDir string, items []map[string]string) (string, error) {
+	m := Manifest{Exam: exam, Src: srcDat, Version: version.Version, Host: host, CreatedAt: time.Now()}
+	previous := make(map[string]ManifestFile)
+	if old, err := ReadManifest(dstDir); err == nil {
+		for _, f := range old.Files {
+			previous[f.Name] = f
+		}
+	}
+	for _, item := range items {
+		info, err := os.Stat(item["dst"])
+		if os.IsNotExist(err) {
+			// 源文件不存在而没有拷贝
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		f := ManifestFile{Name: filepath.Base(item["dst"]), Src: item["src"], Size: info.Size(), ModTime: info.ModTime(), Sha256: item["sha256"]}
+		if isEncoded(item["dst"]) {
+			f.StoredSize = info.Size()
+			if isCompressed(item["dst"]) {
+				f.Compression = CompressGzip
+			}
+			if isEncrypted(item["dst"]) {
+				if f.KeyId, err = readKeyId(item["dst"]); err != nil {
+					return "", err
+				}
+				f.Encryption = crypt.Algorithm
+			}
+			// 不知道还原后的大小时按未写入处理
+			if f.Size, err = strconv.ParseInt(item["size"], 10, 64); err != nil {
+				f.Sha256 = ""
+			}
+		}
+		if t, err := time.Parse(time.RFC3339Nano, item["copied_at"]); err == nil {
+			f.CopiedAt = t
+		}
+		// 本次没有写入时目标文件没有变化, 沿用上一份清单
+		if t, err := time.Parse(time.RFC3339Nano, item["src_mtime"]); err == nil {
+			f.SrcModTime = t
+		} else if old, ok := previous[f.Name]; ok {
+			f.SrcModTime = old.SrcModTime
+		}
+		if f.Sha256 == "" {
+			if old, ok := previous[f.Name]; ok && old.storedSize() == info.Size() && old.ModTime.Equal(f.ModTime) {
+				f.Size, f.Sha256, f.CopiedAt = old.Size, old.Sha256, old.CopiedAt
+			} else if f.Size, f.Sha256, err = hashStored(item["dst"]); err != nil {
+				return "", err
+			}
+		}
+		if f.CopiedAt.IsZero() {
+			f.CopiedAt = m.CreatedAt
+		}
+		m.Files = append(m.Files, f)
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	target := filepath.Join(dstDir, ManifestName)
+	if err := ioutil.WriteFile(target+partSuffix, data, 0644); err != nil {
+		os.Remove(target + partSuffix)
+		return "", err
+	}
+	if err := os.Rename(target+partSuffix, target); err != nil {
+		os.Remove(target + partSuffix)
+		return "", err
+	}
+	return target, nil
+}
+
+// 两份清单列出的文件及内容是否一致, m为nil时不一致
+func (m *Manifest) sameFiles(o *Manifest) bool {
+	if m == nil || o == nil || len(m.Files) != len(o.Files) {
+		return false
+	}
+	for i, f := range m.Files {
+		g := o.Files[i]
+		if f.Name != g.Name || f.Sha256 != g.Sha256 || f.Size != g.Size || f.StoredSize != g.StoredSize ||
+			f.Compression != g.Compression || f.Encryption != g.Encryption || f.KeyId != g.KeyId {
+			return false
+		}
+	}
+	return true
+}
+
+// 目标文件对应的源文件修改时间, 清单中没有记录时(旧版本写入的清单)取目标文件的修改时间
+func srcModTimeOf(dstFile string, dstInfo os.FileInfo) time.Time {
+	if m, err := ReadManifest(filepath.Dir(dstFile)); err == nil {
+		for _, f := range m.Files {
+			if f.Name == filepath.Base(dstFile) && !f.SrcModTime.IsZero() {
+				return f.SrcModTime
+			}
+		}
+	}
+	return dstInfo.ModTime()
+}
+
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, ManifestName))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// 按清单检查目录是否完整, hash为true时同时校验还原后的sha256
+func (m *Manifest) Validate(dir string, hash bool) error {
+	for _, f := range m.Files {
+		p := filepath.Join(dir, f.Name)
+		info, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		if info.Size() != f.storedSize() {
+			return fmt.Errorf("%s 大小 %d 与清单中的 %d 不一致", p, info.Size(), f.storedSize())
+		}
+		if !hash {
+			continue
+		}
+		_, sum, err := hashStored(p)
+		if err != nil {
+			return err
+		}
+		if sum != f.Sha256 {
+			return fmt.Errorf("%s sha256 %s 与清单中的 %s 不一致", p, sum, f.Sha256)
+		}
+	}
+	return nil
+}
+
+// 按清单检查目标检查目录, 没有清单时返回os.IsNotExist的错误
+func ValidateExamDir(dir string, hash bool) error {
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return err
+	}
+	return m.Validate(dir, hash)
+}
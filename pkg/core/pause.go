@@ -0,0 +1,239 @@
+package core
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sanguohot/dcm-timer/etc"
+	"github.com/sanguohot/dcm-timer/pkg/common/log"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 暂停拷贝的原因
+const (
+	PauseManual       = "manual"
+	PauseWindow       = "window"
+	PauseSourceActive = "source_active"
+)
+
+var (
+	// 拷贝已暂停, 检查推迟到下次检索
+	ErrPaused = errors.New("拷贝已暂停, 推迟拷贝")
+	pauser    = &Pauser{touched: make(map[string]time.Time)}
+	// 源目录活动的检查结果缓存时间, 避免每个拷贝者都遍历一次源目录
+	activityCacheTime = 5 * time.Second
+	errActiveDirFound = errors.New("active dir found")
+)
+
+type PauseState struct {
+	Paused bool       `json:"paused"`
+	Reason string     `json:"reason,omitempty"`
+	Detail string     `json:"detail,omitempty"`
+	Since  *time.Time `json:"since,omitempty"`
+}
+
+// 扫描仪采集时暂停拷贝, 避免与采集争用磁盘IO
+// 暂停条件依次为手动暂停、配置的时间窗口、源目录在etc.Get().Pause.SourceActive秒内有修改
+type Pauser struct {
+	lock   sync.Mutex
+	manual bool
+	state  PauseState
+	// 源目录活动检查的缓存, checking为true时已有调用者在遍历源目录
+	checkedAt time.Time
+	activeDir string
+	checking  bool
+	// 本程序自己修改过的源目录及时间, 写拷贝标记等操作不算作采集活动
+	touched map[string]time.Time
+}
+
+// 当前是否暂停, 状态变化时打印日志
+func (p *Pauser) Check() PauseState {
+	p.refreshActivity(time.Now())
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	reason, detail := p.evaluate(time.Now())
+	if reason == p.state.Reason {
+		p.state.Detail = detail
+		return p.state
+	}
+	if reason == "" {
+		log.Info("pause.ended", zap.String("reason", p.state.Reason), log.Elapsed(time.Since(*p.state.Since)))
+		p.state = PauseState{}
+		return p.state
+	}
+	if !p.state.Paused {
+		now := time.Now()
+		p.state.Since = &now
+	}
+	p.state.Paused, p.state.Reason, p.state.Detail = true, reason, detail
+	log.Info("pause.started", zap.String("reason", reason), zap.String("detail", detail))
+	return p.state
+}
+
+func (p *Pauser) evaluate(now time.Time) (string, string) {
+	if p.manual {
+		return PauseManual, ""
+	}
+	if w := findPauseWindow(now); w != "" {
+		return PauseWindow, w
+	}
+	if etc.Get().Pause.SourceActive <= 0 {
+		return "", ""
+	}
+	if p.activeDir != "" {
+		return PauseSourceActive, p.activeDir
+	}
+	return "", ""
+}
+
+// 当前所在的暂停窗口, 不在窗口内时返回空
+func findPauseWindow(now time.Time) string {
+	for _, w := range etc.Get().Pause.Windows {
+		if inPauseWindow(w.Start, w.End, w.Weekdays, now) {
+			return fmt.Sprintf("%s-%s", w.Start, w.End)
+		}
+	}
+	return ""
+}
+
+// 缓存过期时重新检查源目录活动, 遍历源目录时不持有锁, 避免阻塞拷贝者和管理接口
+func (p *Pauser) refreshActivity(now time.Time) {
+	sourceActive := etc.Get().Pause.SourceActive
+	p.lock.Lock()
+	if sourceActive <= 0 || p.checking || p.manual || now.Sub(p.checkedAt) < activityCacheTime || findPauseWindow(now) != "" {
+		p.lock.Unlock()
+		return
+	}
+	p.checking = true
+	touched := make(map[string]time.Time, len(p.touched))
+	for dir, t := range p.touched {
+		touched[dir] = t
+	}
+	p.lock.Unlock()
+
+	after := now.Add(-time.Duration(sourceActive) * time.Second)
+	active := findActiveDir(after, touched)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.checking = false
+	p.checkedAt, p.activeDir = now, active
+	// 超过检查范围的记录不再需要
+	for dir, t := range p.touched {
+		if t.Before(after) {
+			delete(p.touched, dir)
+		}
+	}
+}
+
+// 源目录下修改时间晚于after的目录, touched中本程序修改后没有再变化的目录不算, 没有时返回空
+func findActiveDir(after time.Time, touched map[string]time.Time) string {
+	active := ""
+	scan := etc.Get().Scan
+	err := filepath.Walk(etc.GetSrcPath(), func(srcPath string, info os.FileInfo, err error) error {
+		if info == nil || !info.IsDir() {
+			return nil
+		}
+		if rel, err := filepath.Rel(etc.GetSrcPath(), srcPath); err == nil && rel != "." {
+			if filepath.Clean(srcPath) == filepath.Clean(etc.GetArchivePath()) {
+				return filepath.SkipDir
+			}
+			if scan.MaxDepth > 0 && len(strings.Split(rel, string(filepath.Separator))) > scan.MaxDepth {
+				return filepath.SkipDir
+			}
+			for _, pattern := range scan.ExcludeDirs {
+				if ok, _ := filepath.Match(pattern, info.Name()); ok {
+					return filepath.SkipDir
+				}
+			}
+		}
+		if !info.ModTime().After(after) {
+			return nil
+		}
+		if t, ok := touched[filepath.Clean(srcPath)]; ok && !info.ModTime().After(t) {
+			return nil
+		}
+		active = srcPath
+		return errActiveDirFound
+	})
+	if err != nil && err != errActiveDirFound {
+		log.Warn("pause.check_failed", log.Dir(etc.GetSrcPath()), zap.Error(err))
+	}
+	return active
+}
+
+// 记录本程序修改了源目录dir
+func (p *Pauser) Touch(dir string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.touched[filepath.Clean(dir)] = time.Now()
+}
+
+func (p *Pauser) SetManual(paused bool) {
+	p.lock.Lock()
+	p.manual = paused
+	p.lock.Unlock()
+	p.Check()
+}
+
+// start和end为15:04格式, end早于start时窗口跨零点, weekdays为空表示每天, 0为星期日
+func inPauseWindow(start, end string, weekdays []int, now time.Time) bool {
+	s, err := time.Parse("15:04", start)
+	if err != nil {
+		return false
+	}
+	e, err := time.Parse("15:04", end)
+	if err != nil {
+		return false
+	}
+	onDay := func(day time.Weekday) bool {
+		if len(weekdays) == 0 {
+			return true
+		}
+		for _, d := range weekdays {
+			if time.Weekday(d) == day {
+				return true
+			}
+		}
+		return false
+	}
+	startMin, endMin := s.Hour()*60+s.Minute(), e.Hour()*60+e.Minute()
+	m := now.Hour()*60 + now.Minute()
+	if startMin <= endMin {
+		return startMin <= m && m < endMin && onDay(now.Weekday())
+	}
+	// 跨零点的窗口, 零点之后的部分属于前一天
+	if m >= startMin {
+		return onDay(now.Weekday())
+	}
+	if m < endMin {
+		return onDay((now.Weekday() + 6) % 7)
+	}
+	return false
+}
+
+// 启动时检查暂停窗口配置
+func checkPauseWindows() {
+	for _, w := range etc.Get().Pause.Windows {
+		for _, t := range []string{w.Start, w.End} {
+			if _, err := time.Parse("15:04", t); err != nil {
+				log.Fatal("pause.window_invalid", zap.String("start", w.Start), zap.String("end", w.End), zap.Error(err))
+			}
+		}
+	}
+}
+
+// 当前暂停状态, 供管理接口使用
+func GetPauseState() PauseState {
+	return pauser.Check()
+}
+
+// 手动暂停或恢复拷贝, 手动暂停优先于其他规则
+func SetPaused(paused bool) PauseState {
+	pauser.SetManual(paused)
+	return pauser.Check()
+}
@@ -0,0 +1,224 @@
+package core
+
+import (
+	"container/heap"
+	"context"
+	"github.com/sanguohot/dcm-timer/etc"
+	"github.com/sanguohot/dcm-timer/pkg/common/log"
+	"github.com/sanguohot/dcm-timer/pkg/stream"
+	"go.uber.org/zap"
+	"path"
+	"sync"
+	"time"
+)
+
+var (
+	pipeline = &Pipeline{running: make(map[string]bool), workers: make(map[int]bool), wake: make(chan struct{})}
+)
+
+// 检索与拷贝流水线, 检索定时把候选检查放入队列, 常驻的拷贝者持续从队列中取出拷贝
+// 一个慢的检查只占用一个拷贝者, 不会推迟下一次检索
+type Pipeline struct {
+	lock  sync.Mutex
+	queue CopyQueue
+	// 正在拷贝的检查, 检索到时不再入队
+	running map[string]bool
+	// 有新的检查入队时关闭, 唤醒所有空闲的拷贝者
+	wake chan struct{}
+	// 拷贝者数量, 编号大于size的拷贝者拷贝完当前检查后退出
+	size    int
+	workers map[int]bool
+	// 拷贝使用的ctx, 取消后重新创建
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (p *Pipeline) start(workers int) {
+	p.lock.Lock()
+	p.ctx, p.cancel = context.WithCancel(rootCtx)
+	p.lock.Unlock()
+	p.resize(workers)
+}
+
+// 调整拷贝者数量, 增加时立即启动, 减少时多出的拷贝者拷贝完当前检查后退出
+func (p *Pipeline) resize(workers int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if workers == p.size {
+		return
+	}
+	if p.size > 0 {
+		log.Info("copy.workers_resized", zap.Int("from", p.size), zap.Int("to", workers))
+	}
+	p.size = workers
+	for w := 1; w <= workers; w++ {
+		if !p.workers[w] {
+			p.workers[w] = true
+			go p.worker(w)
+		}
+	}
+	// 唤醒空闲的拷贝者, 多出的随即退出
+	close(p.wake)
+	p.wake = make(chan struct{})
+}
+
+// 当前配置的拷贝者数量
+func (p *Pipeline) Size() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.size
+}
+
+// 用本次检索结果替换队列, 正在拷贝的检查除外
+func (p *Pipeline) Submit(f *Finder) {
+	q := f.NewCopyQueue()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	items := make(CopyQueue, 0, q.Len())
+	found := make(map[string]bool, q.Len())
+	manual := make(map[string]*QueueItem)
+	for _, item := range p.queue {
+		if item.manual {
+			manual[item.Key] = item
+		}
+	}
+	for _, item := range *q {
+		found[item.Key] = true
+		if p.running[item.Key] {
+			continue
+		}
+		// 已手动加入的检查保留手动时的提前和重新拷贝
+		if m, ok := manual[item.Key]; ok {
+			m.Info = item.Info
+			item = m
+		}
+		items = append(items, item)
+		activity.Pending(item.Key, item.Info.Size(), "")
+	}
+	// 手动加入的检查不一定会被检索到, 保留到拷贝为止
+	for _, item := range manual {
+		if !found[item.Key] {
+			items = append(items, item)
+		}
+	}
+	heap.Init(&items)
+	p.queue = items
+	close(p.wake)
+	p.wake = make(chan struct{})
+	log.Info("copy.queued", log.Count(len(items)), zap.Int("running", len(p.running)))
+}
+
+// 手动加入一个检查, 已在排队时合并为手动加入并提前, 正在拷贝时返回false
+func (p *Pipeline) Add(item *QueueItem) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.running[item.Key] {
+		return false
+	}
+	item.manual = true
+	merged := false
+	for i, queued := range p.queue {
+		if queued.Key == item.Key {
+			queued.manual, queued.Starved = true, queued.Starved || item.Starved
+			queued.recopy = queued.recopy || item.recopy
+			heap.Fix(&p.queue, i)
+			merged = true
+			break
+		}
+	}
+	if !merged {
+		heap.Push(&p.queue, item)
+	}
+	activity.Pending(item.Key, item.Info.Size(), "")
+	close(p.wake)
+	p.wake = make(chan struct{})
+	return true
+}
+
+// 取消正在拷贝的检查并清空队列, 队列和拷贝都为空时返回false
+func (p *Pipeline) Cancel() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	busy := len(p.queue) > 0 || len(p.running) > 0
+	p.queue = nil
+	if p.cancel != nil {
+		p.cancel()
+		p.ctx, p.cancel = context.WithCancel(rootCtx)
+	}
+	return busy
+}
+
+// 当前排队和正在拷贝的检查数
+func (p *Pipeline) Len() (int, int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.queue), len(p.running)
+}
+
+// 取出优先级最高的检查, 队列为空时等待, 程序退出或拷贝者id已多出时返回false
+func (p *Pipeline) next(id int) (*QueueItem, context.Context, bool) {
+	for {
+		p.lock.Lock()
+		if id > p.size {
+			delete(p.workers, id)
+			p.lock.Unlock()
+			return nil, nil, false
+		}
+		if len(p.queue) > 0 {
+			item := heap.Pop(&p.queue).(*QueueItem)
+			p.running[item.Key] = true
+			ctx := p.ctx
+			p.lock.Unlock()
+			return item, ctx, true
+		}
+		wake := p.wake
+		p.lock.Unlock()
+		select {
+		case <-rootCtx.Done():
+			return nil, nil, false
+		case <-wake:
+		}
+	}
+}
+
+func (p *Pipeline) worker(id int) {
+	for {
+		item, ctx, ok := p.next(id)
+		if !ok {
+			return
+		}
+		p.run(ctx, id, item)
+	}
+}
+
+func (p *Pipeline) run(ctx context.Context, id int, item *QueueItem) {
+	runningTasks.Add(1)
+	defer runningTasks.Done()
+	defer func() {
+		p.lock.Lock()
+		delete(p.running, item.Key)
+		p.lock.Unlock()
+	}()
+	activity.Copying(item.Key, id, item.Info.Size())
+	defer activity.Release(id)
+	f := item.finder
+	if err := f.CopyWorkerJob(ctx, id, item.Key, item.Info, item.conflictPolicy()); err != nil {
+		f.onJobError(item.Key, err)
+		return
+	}
+	failures.Succeed(item.Key)
+	markCopied(item.Key)
+	activity.Done(item.Key, ExamCopied, "", 0)
+	exam := getSplitName(item.Info.Name())
+	stream.Publish(stream.EventCopyCompleted, exam, map[string]interface{}{
+		"worker": id, "src": item.Key, "dst": path.Join(etc.GetDstPath(), exam),
+	})
+	taskLock.Lock()
+	taskDone[TaskCopy] = time.Now()
+	taskLock.Unlock()
+}
+
+// 当前排队和正在拷贝的检查数, 供管理接口使用
+func GetQueueLen() (int, int) {
+	return pipeline.Len()
+}
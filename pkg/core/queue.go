@@ -0,0 +1,202 @@
+package core
+
+import (
+	"container/heap"
+	stdxml "encoding/xml"
+	"github.com/sanguohot/dcm-timer/etc"
+	"github.com/sanguohot/dcm-timer/pkg/audit"
+	"github.com/sanguohot/dcm-timer/pkg/common/log"
+	"github.com/sanguohot/dcm-timer/pkg/stream"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 拷贝队列的排序键, 按配置顺序逐个比较
+const (
+	OrderPriority  = "priority"
+	OrderMtimeDesc = "mtime_desc"
+	OrderMtimeAsc  = "mtime_asc"
+	OrderSizeAsc   = "size_asc"
+	OrderSizeDesc  = "size_desc"
+)
+
+var (
+	// 检查第一次进入队列的时间, 用于防止积压的旧检查一直排不上
+	firstSeenMap = make(map[string]time.Time)
+	// 最近一次拷贝成功(包括文件已存在而跳过)的检查, 不再参与防饿死排序, 拷贝失败时移除
+	copiedMap     = make(map[string]bool)
+	firstSeenLock sync.Mutex
+)
+
+func markCopied(k string) {
+	firstSeenLock.Lock()
+	defer firstSeenLock.Unlock()
+	copiedMap[k] = true
+}
+
+func markFailed(k string) {
+	firstSeenLock.Lock()
+	defer firstSeenLock.Unlock()
+	delete(copiedMap, k)
+}
+
+type QueueItem struct {
+	Key       string
+	Info      os.FileInfo
+	Priority  int
+	FirstSeen time.Time
+	// 等待超过etc.Get().Queue.StarvationAfter秒, 提到最前
+	Starved bool
+	// 检索到该检查的Finder, 拷贝时使用
+	finder *Finder
+	// 从管理接口手动加入, 不会被下一次检索结果替换
+	manual bool
+	// 手动重新拷贝, 目标文件已存在且不一致时覆盖, 不按etc.Get().ConflictPolicy处理
+	recopy bool
+}
+
+// 目标文件已存在时的处理策略
+func (item *QueueItem) conflictPolicy() string {
+	if item.recopy {
+		return ConflictOverwriteIfDiffer
+	}
+	return etc.Get().ConflictPolicy
+}
+
+type CopyQueue []*QueueItem
+
+func (q CopyQueue) Len() int { return len(q) }
+
+func (q CopyQueue) Less(i, j int) bool {
+	a, b := q[i], q[j]
+	if a.Starved != b.Starved {
+		return a.Starved
+	}
+	if a.Starved {
+		return a.FirstSeen.Before(b.FirstSeen)
+	}
+	for _, order := range etc.Get().Queue.Order {
+		switch order {
+		case OrderPriority:
+			if a.Priority != b.Priority {
+				return a.Priority > b.Priority
+			}
+		case OrderMtimeDesc:
+			if !a.Info.ModTime().Equal(b.Info.ModTime()) {
+				return a.Info.ModTime().After(b.Info.ModTime())
+			}
+		case OrderMtimeAsc:
+			if !a.Info.ModTime().Equal(b.Info.ModTime()) {
+				return a.Info.ModTime().Before(b.Info.ModTime())
+			}
+		case OrderSizeAsc:
+			if a.Info.Size() != b.Info.Size() {
+				return a.Info.Size() < b.Info.Size()
+			}
+		case OrderSizeDesc:
+			if a.Info.Size() != b.Info.Size() {
+				return a.Info.Size() > b.Info.Size()
+			}
+		}
+	}
+	return a.Key < b.Key
+}
+
+func (q CopyQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *CopyQueue) Push(x interface{}) {
+	*q = append(*q, x.(*QueueItem))
+}
+
+func (q *CopyQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	*q = old[:n-1]
+	return item
+}
+
+// 根据本次检索结果生成拷贝队列
+func (f *Finder) NewCopyQueue() *CopyQueue {
+	now := time.Now()
+	starvation := time.Duration(etc.Get().Queue.StarvationAfter) * time.Second
+	q := make(CopyQueue, 0, len(f.PersionMap))
+	firstSeenLock.Lock()
+	seen := make(map[string]time.Time, len(f.PersionMap))
+	copied := make(map[string]bool, len(copiedMap))
+	for k, v := range f.PersionMap {
+		firstSeen, ok := firstSeenMap[k]
+		if !ok {
+			firstSeen = now
+			audit.Append(audit.Record{Action: audit.ActionDiscover, Exam: getSplitName(v.Name()), Src: k, Size: v.Size()})
+			stream.Publish(stream.EventExamDiscovered, getSplitName(v.Name()), map[string]interface{}{"src": k, "size": v.Size()})
+		}
+		seen[k] = firstSeen
+		if copiedMap[k] {
+			copied[k] = true
+		}
+		item := &QueueItem{
+			Key:       k,
+			Info:      v,
+			Priority:  getExamPriority(f.getExamXml(filepath.Dir(k), getSplitName(v.Name()))),
+			FirstSeen: firstSeen,
+			Starved:   starvation > 0 && !copied[k] && now.Sub(firstSeen) > starvation,
+			finder:    f,
+		}
+		if item.Starved {
+			log.Info("queue.starved", log.Exam(getSplitName(v.Name())), log.Src(k), zap.Time("first_seen", firstSeen))
+		}
+		q = append(q, item)
+	}
+	// 已经拷贝或消失的检查不再跟踪
+	firstSeenMap, copiedMap = seen, copied
+	firstSeenLock.Unlock()
+	heap.Init(&q)
+	return &q
+}
+
+// 从检查xml中提取优先级, 命中多个标签时取权重之和
+func getExamPriority(xmlPath string) int {
+	tags := etc.Get().Queue.PriorityTags
+	if len(tags) == 0 {
+		return 0
+	}
+	fp, err := os.Open(xmlPath)
+	if err != nil {
+		log.Warn("queue.priority_failed", log.Path(xmlPath), zap.Error(err))
+		return 0
+	}
+	defer fp.Close()
+	priority := 0
+	element := ""
+	decoder := stdxml.NewDecoder(fp)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Warn("queue.priority_failed", log.Path(xmlPath), zap.Error(err))
+			break
+		}
+		switch t := token.(type) {
+		case stdxml.StartElement:
+			element = t.Name.Local
+		case stdxml.EndElement:
+			element = ""
+		case stdxml.CharData:
+			value := strings.TrimSpace(string(t))
+			for _, tag := range tags {
+				if strings.EqualFold(tag.Element, element) && strings.EqualFold(tag.Value, value) {
+					priority += tag.Weight
+				}
+			}
+		}
+	}
+	return priority
+}
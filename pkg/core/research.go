@@ -0,0 +1,201 @@
+package core
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/sanguohot/dcm-timer/etc"
+	"github.com/sanguohot/dcm-timer/pkg/audit"
+	"github.com/sanguohot/dcm-timer/pkg/common/file"
+	"github.com/sanguohot/dcm-timer/pkg/common/log"
+	"github.com/sanguohot/dcm-timer/pkg/deid"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	// 假名对照表, 在状态目录下, 不写入研究目录
+	pseudonymsPath = path.Join("research", "pseudonyms.json")
+	pseudonyms     *deid.Pseudonyms
+	pseudonymsLock sync.Mutex
+)
+
+// 第一次使用时加载, 之后所有拷贝者共用
+func getPseudonyms() (*deid.Pseudonyms, error) {
+	pseudonymsLock.Lock()
+	defer pseudonymsLock.Unlock()
+	if pseudonyms != nil {
+		return pseudonyms, nil
+	}
+	p, err := deid.LoadPseudonyms(path.Join(etc.GetStatePath(), pseudonymsPath), etc.Get().Research.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	pseudonyms = p
+	return p, nil
+}
+
+// 按etc.Get().Research.Profile生成去标识规则, 每次读取配置, 重新加载配置后立即生效
+func getResearchProfile() (*deid.Profile, error) {
+	table, err := getPseudonyms()
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]deid.Rule, 0, len(etc.Get().Research.Profile))
+	for _, r := range etc.Get().Research.Profile {
+		rules = append(rules, deid.Rule{Element: r.Element, Action: r.Action, Value: r.Value})
+	}
+	return deid.NewProfile(rules, table)
+}
+
+// 扩展名在etc.Get().Research.Extensions中的原始数据原样导出
+// hdr头文件可能含有患者信息, 去标识规则只处理xml, 即使配置了也不导出
+func isResearchRaw(name string) bool {
+	ext := strings.TrimPrefix(filepath.Ext(name), ".")
+	if strings.EqualFold(ext, hdr) {
+		return false
+	}
+	for _, e := range etc.Get().Research.Extensions {
+		if strings.EqualFold(strings.TrimPrefix(e, "."), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// 启动时检查研究导出配置, 配置了不能去标识的头文件时告警
+func checkResearch() {
+	if !etc.Get().Research.Enabled {
+		return
+	}
+	for _, e := range etc.Get().Research.Extensions {
+		if strings.EqualFold(strings.TrimPrefix(e, "."), hdr) {
+			log.Warn("research.raw_refused", zap.String("extension", e))
+		}
+	}
+}
+
+// 研究目录中的文件不早于源文件, 原始数据还要大小一致时, 认为已导出
+// 修改去标识规则后已导出的xml不会重新导出
+func researchUpToDate(f ManifestFile, target string, raw bool) bool {
+	info, err := os.Stat(target)
+	if err != nil {
+		return false
+	}
+	if raw && info.Size() != f.Size {
+		return false
+	}
+	srcModTime := f.SrcModTime
+	if srcModTime.IsZero() {
+		srcModTime = f.ModTime
+	}
+	return !info.ModTime().Before(srcModTime)
+}
+
+// 临床拷贝和清单完成后, 把检查去标识后写入研究目录: xml按规则改写, 原始数据见isResearchRaw, 其他文件不导出
+// 读取的是目标目录中按清单还原的文件, 源文件移动或删除后也能导出; 失败只记录日志和审计, 不影响检查的拷贝结果
+func exportResearch(ctx context.Context, id int, exam, dstDir string, items []map[string]string) {
+	if !etc.Get().Research.Enabled {
+		return
+	}
+	dir, exported, err := doExportResearch(ctx, exam, dstDir, items)
+	if err != nil {
+		audit.Append(audit.Record{Action: audit.ActionResearch, Exam: exam, Src: dstDir, Dst: dir,
+			Outcome: audit.OutcomeFailed, Detail: err.Error()})
+		log.Error("research.failed", log.Worker(id), log.Exam(exam), log.Dir(dstDir), zap.Error(err))
+		return
+	}
+	// 研究目录已是最新时不重复记录
+	if exported == 0 {
+		return
+	}
+	audit.Append(audit.Record{Action: audit.ActionResearch, Exam: exam, Src: dstDir, Dst: dir})
+	log.Info("research.exported", log.Worker(id), log.Exam(exam), log.Dir(dir), log.Count(exported))
+}
+
+// 返回研究目录和本次导出的文件数, 目录名和文件名中的检查号替换为假名
+func doExportResearch(ctx context.Context, exam, dstDir string, items []map[string]string) (string, int, error) {
+	profile, err := getResearchProfile()
+	if err != nil {
+		return "", 0, err
+	}
+	table, err := getPseudonyms()
+	if err != nil {
+		return "", 0, err
+	}
+	anon, err := table.Get("exam", exam)
+	if err != nil {
+		return "", 0, err
+	}
+	manifest, err := ReadManifest(dstDir)
+	if err != nil {
+		return "", 0, err
+	}
+	files := make(map[string]ManifestFile, len(manifest.Files))
+	for _, f := range manifest.Files {
+		files[f.Name] = f
+	}
+	dir := path.Join(etc.GetResearchPath(), anon)
+	exported := 0
+	for _, item := range items {
+		if err := ctx.Err(); err != nil {
+			return dir, exported, err
+		}
+		// 源文件不存在而没有拷贝的不在清单中
+		f, ok := files[filepath.Base(item["dst"])]
+		if !ok {
+			continue
+		}
+		target := filepath.Join(dir, strings.Replace(filepath.Base(item["src"]), exam, anon, -1))
+		isXml := strings.EqualFold(filepath.Ext(target), "."+xml)
+		if !isXml && !isResearchRaw(target) {
+			continue
+		}
+		if researchUpToDate(f, target, !isXml) {
+			continue
+		}
+		if err := file.EnsureDir(dir); err != nil {
+			return dir, exported, err
+		}
+		rewrite := profile.Rewrite
+		if !isXml {
+			rewrite = copyAll
+		}
+		if err := exportStored(item["dst"], target, rewrite); err != nil {
+			return dir, exported, errors.Wrap(err, "导出研究数据失败")
+		}
+		exported++
+	}
+	return dir, exported, nil
+}
+
+func copyAll(r io.Reader, w io.Writer) error {
+	_, err := io.Copy(w, r)
+	return err
+}
+
+// 读取还原后的目标文件写入研究目录, 先写临时文件再改名, 研究目录中不会出现写了一半的文件
+func exportStored(dstFile, target string, rewrite func(io.Reader, io.Writer) error) error {
+	in, err := openStored(dstFile)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(target + partSuffix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(target + partSuffix)
+	if err := rewrite(in, out); err != nil {
+		out.Close()
+		return errors.Wrap(err, dstFile)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(target+partSuffix, target)
+}
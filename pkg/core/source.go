@@ -0,0 +1,206 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sanguohot/dcm-timer/etc"
+	"github.com/sanguohot/dcm-timer/pkg/audit"
+	"github.com/sanguohot/dcm-timer/pkg/common/file"
+	"github.com/sanguohot/dcm-timer/pkg/common/log"
+	"github.com/sanguohot/dcm-timer/pkg/stream"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 拷贝成功后对源文件的处理方式
+const (
+	PostCopyLeave  = "leave"
+	PostCopyMark   = "mark"
+	PostCopyMove   = "move"
+	PostCopyDelete = "delete"
+)
+
+var (
+	copiedSuffix = ".copied"
+)
+
+type CopiedFile struct {
+	Src    string `json:"src"`
+	Dst    string `json:"dst"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+// 拷贝完成标记, 写在源目录Prep_*.dat旁边, 记录拷贝时dat的大小和修改时间
+// dat被重新写入后标记失效, 会重新拷贝
+type CopiedMarker struct {
+	CopiedAt   time.Time    `json:"copied_at"`
+	DatSize    int64        `json:"dat_size"`
+	DatModTime time.Time    `json:"dat_mod_time"`
+	Files      []CopiedFile `json:"files"`
+}
+
+func markerPath(srcDat string) string {
+	return srcDat + copiedSuffix
+}
+
+func readMarker(srcDat string) (*CopiedMarker, error) {
+	data, err := ioutil.ReadFile(markerPath(srcDat))
+	if err != nil {
+		return nil, err
+	}
+	var marker CopiedMarker
+	if err := json.Unmarshal(data, &marker); err != nil {
+		return nil, err
+	}
+	return &marker, nil
+}
+
+func (m *CopiedMarker) Match(info os.FileInfo) bool {
+	return m.DatSize == info.Size() && m.DatModTime.Equal(info.ModTime())
+}
+
+// 校验源文件和目标文件的sha256一致, 源文件不存在的跳过
+func verifyCopiedFiles(items []map[string]string) ([]CopiedFile, error) {
+	files := make([]CopiedFile, 0, len(items))
+	for _, item := range items {
+		srcInfo, err := os.Stat(item["src"])
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		srcHash, err := file.HashFile(item["src"])
+		if err != nil {
+			return nil, err
+		}
+		_, dstHash, err := hashStored(item["dst"])
+		if err != nil {
+			return nil, err
+		}
+		if srcHash != dstHash {
+			return nil, fmt.Errorf("校验失败 %s(%s) != %s(%s)", item["src"], srcHash, item["dst"], dstHash)
+		}
+		files = append(files, CopiedFile{Src: item["src"], Dst: item["dst"], Size: srcInfo.Size(), Sha256: srcHash})
+	}
+	return files, nil
+}
+
+// 拷贝成功后按etc.Get().PostCopy.Action处理源文件, 只有目标文件校验通过后才会改动源文件
+func (f *Finder) afterCopy(id int, srcDat string, items []map[string]string) error {
+	action := etc.Get().PostCopy.Action
+	if action == "" || action == PostCopyLeave {
+		return nil
+	}
+	datInfo, err := os.Stat(srcDat)
+	if err != nil {
+		return err
+	}
+	exam := getSplitName(filepath.Base(srcDat))
+	files, err := verifyCopiedFiles(items)
+	if err != nil {
+		audit.Append(audit.Record{Action: audit.ActionVerify, Exam: exam, Src: srcDat, Outcome: audit.OutcomeFailed, Detail: err.Error()})
+		return err
+	}
+	for _, item := range files {
+		audit.Append(audit.Record{Action: audit.ActionVerify, Exam: exam, Src: item.Src, Dst: item.Dst, Size: item.Size, Sha256: item.Sha256})
+	}
+	stream.Publish(stream.EventCopyVerified, exam, map[string]interface{}{"worker": id, "src": srcDat, "files": files})
+	switch action {
+	case PostCopyMark, PostCopyDelete:
+		// 删除模式先写标记, 等待etc.Get().PostCopy.DeleteDelay秒后由后续检索删除
+		marker := CopiedMarker{CopiedAt: time.Now(), DatSize: datInfo.Size(), DatModTime: datInfo.ModTime(), Files: files}
+		data, err := json.MarshalIndent(marker, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(markerPath(srcDat), data, 0644); err != nil {
+			return err
+		}
+		pauser.Touch(filepath.Dir(srcDat))
+		log.Info("post_copy.marked", log.Worker(id), log.Exam(exam), log.Path(markerPath(srcDat)))
+	case PostCopyMove:
+		for _, item := range files {
+			dst, err := archivePath(item.Src)
+			if err != nil {
+				return err
+			}
+			if err := file.Move(item.Src, dst); err != nil {
+				return err
+			}
+			pauser.Touch(filepath.Dir(item.Src))
+			audit.Append(audit.Record{Action: audit.ActionArchive, Exam: exam, Src: item.Src, Dst: dst, Size: item.Size, Sha256: item.Sha256})
+			log.Info("post_copy.archived", log.Worker(id), log.Exam(exam), log.Src(item.Src), log.Dst(dst))
+		}
+	default:
+		return fmt.Errorf("非法的配置项：拷贝后处理方式 %s", action)
+	}
+	return nil
+}
+
+// 源文件在归档目录中的位置, 保持相对源目录的路径不变
+func archivePath(src string) (string, error) {
+	rel, err := filepath.Rel(etc.GetSrcPath(), src)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(etc.GetArchivePath(), rel), nil
+}
+
+// 检查Prep_*.dat是否已拷贝过, 删除模式下顺便登记超过延时的待删除文件
+func (f *Finder) checkCopied(srcDat string, info os.FileInfo) bool {
+	marker, err := readMarker(srcDat)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn("post_copy.marker_invalid", log.Path(markerPath(srcDat)), zap.Error(err))
+		}
+		return false
+	}
+	if !marker.Match(info) {
+		log.Info("post_copy.source_modified", log.Exam(getSplitName(info.Name())), log.Src(srcDat))
+		return false
+	}
+	if etc.Get().PostCopy.Action == PostCopyDelete &&
+		time.Since(marker.CopiedAt) > time.Duration(etc.Get().PostCopy.DeleteDelay)*time.Second {
+		f.DeleteMap[srcDat] = marker
+	}
+	return true
+}
+
+// 删除已拷贝且超过延时的源文件, 删除前再次校验目标文件, 目标文件缺失或不一致时保留源文件
+func (f *Finder) DeleteCopiedSources() {
+	for srcDat, marker := range f.DeleteMap {
+		if err := deleteCopiedSource(srcDat, marker); err != nil {
+			log.Error("post_copy.delete_failed", log.Exam(getSplitName(filepath.Base(srcDat))), log.Src(srcDat), zap.Error(err))
+			continue
+		}
+		log.Info("post_copy.deleted", log.Exam(getSplitName(filepath.Base(srcDat))), log.Src(srcDat))
+	}
+}
+
+func deleteCopiedSource(srcDat string, marker *CopiedMarker) error {
+	for _, item := range marker.Files {
+		_, dstHash, err := hashStored(item.Dst)
+		if err != nil {
+			return err
+		}
+		if dstHash != item.Sha256 {
+			return fmt.Errorf("目标文件 %s 校验失败, 保留源文件", item.Dst)
+		}
+	}
+	exam := getSplitName(filepath.Base(srcDat))
+	for _, item := range marker.Files {
+		if err := os.Remove(item.Src); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		pauser.Touch(filepath.Dir(item.Src))
+		audit.Append(audit.Record{Action: audit.ActionDelete, Exam: exam, Src: item.Src, Size: item.Size, Sha256: item.Sha256,
+			Detail: "拷贝校验通过后删除源文件"})
+	}
+	pauser.Touch(filepath.Dir(srcDat))
+	return os.Remove(markerPath(srcDat))
+}
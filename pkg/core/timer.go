@@ -1,44 +1,241 @@
 package core
 
 import (
+	"context"
 	"github.com/sanguohot/dcm-timer/etc"
 	"github.com/sanguohot/dcm-timer/pkg/common/log"
+	"github.com/sanguohot/dcm-timer/pkg/notify"
+	"go.uber.org/zap"
+	"sync"
 	"time"
 )
 
+// 定时任务名, 用于日志和通知
+const (
+	TaskScan           = "scan"
+	TaskCopy           = "copy"
+	TaskClean          = "clean"
+	TaskEmergencyClean = "emergency_clean"
+	TaskVerify         = "verify"
+)
+
+var (
+	// 程序退出时取消所有任务
+	rootCtx, stopTasks = context.WithCancel(context.Background())
+	runningTasks       sync.WaitGroup
+	// 取消正在进行的检索和拷贝, 不影响下一次检索
+	scanLock   sync.Mutex
+	scanCancel context.CancelFunc
+	// 正在运行的任务及开始时间, 同一任务不重叠运行
+	taskLock    sync.Mutex
+	taskRunning = make(map[string]time.Time)
+	// 任务最近一次成功完成的时间, 供健康检查使用
+	taskDone  = make(map[string]time.Time)
+	taskNext  = make(map[string]time.Time)
+	startedAt time.Time
+	// 定时任务循环的心跳, 循环等待下一次执行时每heartbeatInterval更新一次, 供看门狗判断循环是否存活
+	heartbeats        = make(map[string]time.Time)
+	heartbeatInterval = 10 * time.Second
+	// 共用运行状态的任务, 紧急清除与定时清除不能同时运行
+	taskGroups = map[string]string{TaskEmergencyClean: TaskClean}
+)
+
 func timerTask() {
 	go func() {
 		for {
-			exeTaskAndCalcTime("拷贝", NewFinder().FindAndCopy)
+			ctx, cancel := context.WithCancel(rootCtx)
+			setScanCancel(cancel)
+			cost := exeTaskAndCalcTime(ctx, TaskScan, NewFinder().FindAndCopy)
+			setScanCancel(nil)
+			cancel()
+			if interval := time.Duration(etc.Get().Interval) * time.Second; cost > interval {
+				log.Warn("task.overrun", zap.String("task", TaskScan), log.Elapsed(cost), zap.Float64("interval", interval.Seconds()))
+				notify.Send(notify.EventScanOverrun, log.Msg("task.overrun"), map[string]interface{}{
+					"task": TaskScan, "elapsed": cost.Seconds(), "interval": interval.Seconds(),
+				})
+			}
 			// 任务执行完毕后，计算下一次执行的时间
 			now := time.Now()
-			next := now.Add(time.Duration(etc.Config.Interval) * time.Second)
-			<-time.NewTimer(next.Sub(now)).C
+			next := now.Add(time.Duration(etc.Get().Interval) * time.Second)
+			setTaskNext(TaskScan, next)
+			if !sleepUntil(TaskScan, next) {
+				return
+			}
 		}
 	}()
 }
 
-func exeTaskAndCalcTime(task string, f func()) {
+// 等待到t, 期间定期更新task循环的心跳, 程序退出时返回false
+func sleepUntil(task string, t time.Time) bool {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		beat(task)
+		select {
+		case <-rootCtx.Done():
+			return false
+		case <-timer.C:
+			return true
+		case <-ticker.C:
+		}
+	}
+}
+
+func beat(task string) {
+	taskLock.Lock()
+	defer taskLock.Unlock()
+	heartbeats[task] = time.Now()
+}
+
+func setTaskNext(task string, t time.Time) {
+	taskLock.Lock()
+	defer taskLock.Unlock()
+	taskNext[task] = t
+}
+
+func setScanCancel(cancel context.CancelFunc) {
+	scanLock.Lock()
+	defer scanLock.Unlock()
+	scanCancel = cancel
+}
+
+// 取消正在进行的检索和拷贝并清空拷贝队列, 没有正在进行的检索和拷贝时返回false
+func CancelScan() bool {
+	log.Info("task.cancel_requested", zap.String("task", TaskScan))
+	cancelled := pipeline.Cancel()
+	scanLock.Lock()
+	defer scanLock.Unlock()
+	if scanCancel != nil {
+		scanCancel()
+		cancelled = true
+	}
+	return cancelled
+}
+
+// 任务开始运行, 同组任务正在运行时返回false
+func acquireTask(task string) bool {
+	group := task
+	if g, ok := taskGroups[task]; ok {
+		group = g
+	}
+	taskLock.Lock()
+	defer taskLock.Unlock()
+	if since, ok := taskRunning[group]; ok {
+		log.Warn("task.overlap", zap.String("task", task), zap.String("running", group), zap.Time("since", since))
+		return false
+	}
+	taskRunning[group] = time.Now()
+	return true
+}
+
+func releaseTask(task string) {
+	group := task
+	if g, ok := taskGroups[task]; ok {
+		group = g
+	}
+	taskLock.Lock()
+	defer taskLock.Unlock()
+	delete(taskRunning, group)
+}
+
+// 执行任务并返回耗时, 上一次同组任务还在运行时跳过本次
+func exeTaskAndCalcTime(ctx context.Context, task string, f func(context.Context)) time.Duration {
+	if !acquireTask(task) {
+		return 0
+	}
+	defer releaseTask(task)
+	runningTasks.Add(1)
+	defer runningTasks.Done()
+	// 任务运行时间上限, 超时取消; 取消后仍不返回的由存活检查判定停滞
+	if timeout := etc.Get().TaskTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+	}
 	now := time.Now()
-	f()
-	log.Sugar.Infof("%s任务执行完毕, 耗时 ===> %f 秒", task, time.Since(now).Seconds())
+	f(ctx)
+	cost := time.Since(now)
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Warn("task.timeout", zap.String("task", task), log.Elapsed(cost))
+		return cost
+	}
+	if ctx.Err() != nil {
+		log.Info("task.cancelled", zap.String("task", task), log.Elapsed(cost))
+		return cost
+	}
+	log.Info("task.done", zap.String("task", task), log.Elapsed(cost))
+	taskLock.Lock()
+	taskDone[task] = time.Now()
+	taskLock.Unlock()
+	return cost
+}
+
+func verifyTask() {
+	if etc.Get().Verify.Interval <= 0 {
+		return
+	}
+	go func() {
+		for {
+			next := time.Now().Add(time.Duration(etc.Get().Verify.Interval) * time.Second)
+			setTaskNext(TaskVerify, next)
+			if !sleepUntil(TaskVerify, next) {
+				return
+			}
+			exeTaskAndCalcTime(rootCtx, TaskVerify, func(ctx context.Context) {
+				if _, err := RunVerify(ctx, etc.Get().Verify.Hash); err != nil && ctx.Err() == nil {
+					log.Error("verify.failed", zap.Error(err))
+				}
+			})
+		}
+	}()
 }
 
 func cleanTask() {
 	go func() {
 		for {
-			exeTaskAndCalcTime("清除", NewCleaner().Clean)
+			exeTaskAndCalcTime(rootCtx, TaskClean, NewCleaner().Clean)
 			// 任务执行完毕后，计算下一次执行的时间
 			now := time.Now()
 			next := now.Add(time.Hour * 24)
 			// 每天0点定时执行
 			next = time.Date(next.Year(), next.Month(), next.Day(), 0, 0, 0, 0, next.Location())
-			<-time.NewTimer(next.Sub(now)).C
+			setTaskNext(TaskClean, next)
+			if !sleepUntil(TaskClean, next) {
+				return
+			}
 		}
 	}()
 }
 
-func init() {
+// 启动定时拷贝、清除和核对任务
+func Start() {
+	checkPauseWindows()
+	checkResearch()
+	startedAt = time.Now()
+	pipeline.start(etc.Get().MaxWorker)
 	cleanTask()
 	timerTask()
+	verifyTask()
+}
+
+// 重新加载配置后调整拷贝者数量, 其他配置在下一次使用时读取
+func Reload() {
+	pipeline.resize(etc.Get().MaxWorker)
+}
+
+// 取消所有任务并等待退出, 最多等待timeout, 挂起的拷贝不再等待
+func Stop(timeout time.Duration) {
+	stopTasks()
+	done := make(chan struct{})
+	go func() {
+		runningTasks.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Warn("task.stop_timeout", zap.Duration("timeout", timeout))
+	}
 }
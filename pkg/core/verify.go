@@ -0,0 +1,253 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/sanguohot/dcm-timer/etc"
+	"github.com/sanguohot/dcm-timer/pkg/common/file"
+	"github.com/sanguohot/dcm-timer/pkg/common/log"
+	"go.uber.org/zap"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	verifyDir = "verify"
+)
+
+type VerifyItem struct {
+	Exam   string `json:"exam"`
+	Src    string `json:"src,omitempty"`
+	Dst    string `json:"dst,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// 源目录与目标目录的核对报告
+type VerifyReport struct {
+	StartedAt    time.Time    `json:"started_at"`
+	FinishedAt   time.Time    `json:"finished_at"`
+	Source       string       `json:"source"`
+	Output       string       `json:"output"`
+	Hash         bool         `json:"hash"`
+	Exams        int          `json:"exams"`
+	Missing      []VerifyItem `json:"missing"`
+	Extra        []VerifyItem `json:"extra"`
+	SizeMismatch []VerifyItem `json:"size_mismatch"`
+	HashMismatch []VerifyItem `json:"hash_mismatch"`
+	// 报告文件的路径
+	Path string `json:"-"`
+}
+
+func (r *VerifyReport) OK() bool {
+	return len(r.Missing) == 0 && len(r.Extra) == 0 && len(r.SizeMismatch) == 0 && len(r.HashMismatch) == 0
+}
+
+// 核对源目录和目标目录, 报告写入状态目录, hash为true时比较sha256
+func RunVerify(ctx context.Context, hash bool) (*VerifyReport, error) {
+	report := &VerifyReport{
+		StartedAt:    time.Now(),
+		Source:       etc.GetSrcPath(),
+		Output:       etc.GetDstPath(),
+		Hash:         hash,
+		Missing:      []VerifyItem{},
+		Extra:        []VerifyItem{},
+		SizeMismatch: []VerifyItem{},
+		HashMismatch: []VerifyItem{},
+	}
+	// 与拷贝使用相同的筛选规则, 已拷贝的检查也需要核对
+	f := NewFinder()
+	f.IncludeCopied = true
+	f.ShowFileList(ctx)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	hold := getHoldTime()
+	expected := make(map[string]map[string]bool)
+	for k, v := range f.PersionMap {
+		splitName := getSplitName(v.Name())
+		// 超过保留期限的检查会被清除, 不再核对
+		if da, err := parseDirDate(splitName, prefix); err == nil && da.Before(hold) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		report.Exams++
+		expected[splitName] = make(map[string]bool)
+		for _, item := range f.GetCopyItems(k, v) {
+			expected[splitName][filepath.Base(item["dst"])] = true
+			if err := report.verifyItem(splitName, item["src"], item["dst"]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := report.findExtra(expected); err != nil {
+		return nil, err
+	}
+	report.FinishedAt = time.Now()
+	if err := report.save(); err != nil {
+		return report, err
+	}
+	log.Info("verify.done", log.Count(report.Exams), zap.Int("missing", len(report.Missing)), zap.Int("extra", len(report.Extra)),
+		zap.Int("size_mismatch", len(report.SizeMismatch)), zap.Int("hash_mismatch", len(report.HashMismatch)), log.Path(report.Path))
+	return report, nil
+}
+
+func (r *VerifyReport) verifyItem(splitName, src, dst string) error {
+	srcInfo, err := os.Stat(src)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	// 保留两者策略下, 与任一版本一致即可
+	candidates := []string{dst}
+	for v := 2; file.FilePathExist(versionedName(dst, v)); v++ {
+		candidates = append(candidates, versionedName(dst, v))
+	}
+	sizeMatched := ""
+	exist := false
+	for _, candidate := range candidates {
+		dstInfo, err := os.Stat(candidate)
+		if err != nil {
+			continue
+		}
+		exist = true
+		dstSize, err := storedSize(candidate, dstInfo)
+		if err != nil {
+			return err
+		}
+		if dstSize == srcInfo.Size() {
+			sizeMatched = candidate
+			break
+		}
+	}
+	if !exist {
+		r.Missing = append(r.Missing, VerifyItem{Exam: splitName, Src: src, Dst: dst})
+		return nil
+	}
+	if sizeMatched == "" {
+		r.SizeMismatch = append(r.SizeMismatch, VerifyItem{Exam: splitName, Src: src, Dst: dst,
+			Detail: fmt.Sprintf("源文件 %d 字节", srcInfo.Size())})
+		return nil
+	}
+	if !r.Hash {
+		return nil
+	}
+	srcHash, err := file.HashFile(src)
+	if err != nil {
+		return err
+	}
+	_, dstHash, err := hashStored(sizeMatched)
+	if err != nil {
+		return err
+	}
+	if srcHash != dstHash {
+		r.HashMismatch = append(r.HashMismatch, VerifyItem{Exam: splitName, Src: src, Dst: sizeMatched,
+			Detail: fmt.Sprintf("%s != %s", srcHash, dstHash)})
+	}
+	return nil
+}
+
+// 目标目录中没有对应源检查且清单不完整的目录, 以及检查目录中不应存在的文件
+func (r *VerifyReport) findExtra(expected map[string]map[string]bool) error {
+	infos, err := ioutil.ReadDir(etc.GetDstPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if !info.IsDir() || !strings.HasPrefix(info.Name(), prefix) {
+			continue
+		}
+		dstDir := path.Join(etc.GetDstPath(), info.Name())
+		files, ok := expected[info.Name()]
+		if !ok {
+			// 源文件已按post_copy移走或删除的检查, 清单完整时不算多余
+			m, err := ReadManifest(dstDir)
+			if err != nil {
+				r.Extra = append(r.Extra, VerifyItem{Exam: info.Name(), Dst: dstDir})
+				continue
+			}
+			if err := m.Validate(dstDir, r.Hash); err != nil {
+				r.Extra = append(r.Extra, VerifyItem{Exam: info.Name(), Dst: dstDir, Detail: err.Error()})
+				continue
+			}
+			files = make(map[string]bool, len(m.Files))
+			for _, f := range m.Files {
+				files[stripVersion(f.Name)] = true
+			}
+		}
+		children, err := ioutil.ReadDir(dstDir)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			if child.Name() == ManifestName {
+				continue
+			}
+			if !files[stripVersion(child.Name())] {
+				r.Extra = append(r.Extra, VerifyItem{Exam: info.Name(), Dst: path.Join(dstDir, child.Name())})
+			}
+		}
+	}
+	return nil
+}
+
+// Prep_x.v2.dat => Prep_x.dat, Prep_x.v2.dat.gz => Prep_x.dat.gz
+func stripVersion(name string) string {
+	name, suffix := splitStoredName(name)
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if i := strings.LastIndex(base, ".v"); i >= 0 {
+		if _, err := fmt.Sscanf(base[i+2:], "%d", new(int)); err == nil {
+			return base[:i] + ext + suffix
+		}
+	}
+	return name + suffix
+}
+
+func (r *VerifyReport) save() error {
+	dir := path.Join(etc.GetStatePath(), verifyDir)
+	if err := file.EnsureDir(dir); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	r.Path = path.Join(dir, fmt.Sprintf("verify-%s.json", r.StartedAt.Format("20060102150405")))
+	return ioutil.WriteFile(r.Path, data, 0644)
+}
+
+// 输出便于阅读的核对结果
+func (r *VerifyReport) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "源目录: %s\n目标目录: %s\n", r.Source, r.Output)
+	fmt.Fprintf(w, "核对时间: %s, 耗时 %.1f 秒, 比较校验值: %v\n", r.StartedAt.Format(layout), r.FinishedAt.Sub(r.StartedAt).Seconds(), r.Hash)
+	fmt.Fprintf(w, "检查数: %d\n", r.Exams)
+	sections := []struct {
+		title string
+		items []VerifyItem
+	}{
+		{"目标目录缺失", r.Missing},
+		{"目标目录多余", r.Extra},
+		{"大小不一致", r.SizeMismatch},
+		{"校验值不一致", r.HashMismatch},
+	}
+	for _, section := range sections {
+		fmt.Fprintf(w, "%s: %d\n", section.title, len(section.items))
+		for _, item := range section.items {
+			fmt.Fprintf(w, "  [%s] %s %s %s\n", item.Exam, item.Src, item.Dst, item.Detail)
+		}
+	}
+	if r.Path != "" {
+		fmt.Fprintf(w, "报告文件: %s\n", r.Path)
+	}
+}
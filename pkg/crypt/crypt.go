@@ -0,0 +1,261 @@
+package crypt
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// 分块的AES-256-GCM流式加密格式:
+// 文件头为 magic(8) + 密钥编号(8) + 块大小(4) + nonce前缀(7), 之后每块为最多块大小的明文加密后的密文和16字节tag
+// 每块的nonce为 nonce前缀 + 块序号(4) + 是否最后一块(1), 文件头作为附加数据, 截断、调换和替换块都无法通过校验
+const (
+	Algorithm = "aes-256-gcm"
+	KeySize   = 32
+	ChunkSize = 64 * 1024
+)
+
+var (
+	magic = []byte("DCMTENC1")
+
+	ErrFormat  = errors.New("不是加密文件或文件头已损坏")
+	ErrKey     = errors.New("密钥与加密文件不匹配")
+	ErrCorrupt = errors.New("解密失败, 文件已损坏或被篡改")
+)
+
+const (
+	headerSize  = 8 + 8 + 4 + 7
+	noncePrefix = 7
+)
+
+// 密钥编号, 写入文件头用于判断解密时是否使用了正确的密钥
+func KeyId(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// 读取加密文件头中的密钥编号
+func ReadKeyId(r io.Reader) (string, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:8]) != string(magic) {
+		return "", ErrFormat
+	}
+	return hex.EncodeToString(header[8:16]), nil
+}
+
+// 生成随机密钥
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// 解析hex或base64编码的密钥, 也接受32字节的原始密钥
+func ParseKey(data []byte) ([]byte, error) {
+	if len(data) == KeySize {
+		return data, nil
+	}
+	s := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(s); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("密钥必须是%d字节, 可以用hex或base64编码", KeySize)
+}
+
+// 优先读取环境变量env中的密钥, 没有时读取密钥文件
+func LoadKey(keyFile, env string) ([]byte, error) {
+	if env != "" {
+		if s := os.Getenv(env); s != "" {
+			key, err := ParseKey([]byte(s))
+			if err != nil {
+				return nil, fmt.Errorf("环境变量 %s: %s", env, err.Error())
+			}
+			return key, nil
+		}
+	}
+	if keyFile == "" {
+		return nil, fmt.Errorf("没有配置密钥文件, 环境变量 %s 也为空", env)
+	}
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParseKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("密钥文件 %s: %s", keyFile, err.Error())
+	}
+	return key, nil
+}
+
+func newAead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix []byte, seq uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefix:], seq)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+type writer struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	seq    uint32
+	buf    []byte
+	closed bool
+}
+
+// 加密写入w, Close时写入最后一块, 不关闭w
+func NewWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	aead, err := newAead(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	id, _ := hex.DecodeString(KeyId(key))
+	copy(header[8:], id)
+	binary.BigEndian.PutUint32(header[16:], ChunkSize)
+	if _, err := io.ReadFull(rand.Reader, header[20:]); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &writer{w: w, aead: aead, header: header, prefix: header[20:], buf: make([]byte, 0, ChunkSize)}, nil
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("加密流已关闭")
+	}
+	n := 0
+	for len(p) > 0 {
+		// 缓冲区满且还有数据时才写出, 保证最后一块在Close时写出
+		if len(w.buf) == ChunkSize {
+			if err := w.flush(false); err != nil {
+				return n, err
+			}
+		}
+		m := copy(w.buf[len(w.buf):ChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+func (w *writer) flush(last bool) error {
+	if w.seq == ^uint32(0) {
+		return errors.New("加密文件过大")
+	}
+	out := w.aead.Seal(nil, chunkNonce(w.prefix, w.seq, last), w.buf, w.header)
+	w.seq++
+	w.buf = w.buf[:0]
+	_, err := w.w.Write(out)
+	return err
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.flush(true)
+}
+
+type reader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	chunk  int
+	seq    uint32
+	buf    []byte
+	out    []byte
+	done   bool
+}
+
+// 解密读取r, 读到结尾前每块都经过校验, 文件被截断时返回ErrCorrupt
+func NewReader(r io.Reader, key []byte) (io.Reader, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrFormat
+	}
+	if string(header[:8]) != string(magic) {
+		return nil, ErrFormat
+	}
+	if hex.EncodeToString(header[8:16]) != KeyId(key) {
+		return nil, ErrKey
+	}
+	chunk := int(binary.BigEndian.Uint32(header[16:]))
+	if chunk <= 0 || chunk > 16*1024*1024 {
+		return nil, ErrFormat
+	}
+	aead, err := newAead(key)
+	if err != nil {
+		return nil, err
+	}
+	return &reader{r: bufio.NewReaderSize(r, chunk+aead.Overhead()+1), aead: aead, header: header, prefix: header[20:],
+		chunk: chunk, buf: make([]byte, chunk+aead.Overhead())}, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *reader) next() error {
+	n, err := io.ReadFull(r.r, r.buf)
+	last := false
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		last = true
+	} else if err != nil {
+		return err
+	} else if _, err := r.r.Peek(1); err == io.EOF {
+		last = true
+	}
+	plain, err := r.aead.Open(r.buf[:0], chunkNonce(r.prefix, r.seq, last), r.buf[:n], r.header)
+	if err != nil {
+		return ErrCorrupt
+	}
+	r.seq++
+	r.out = plain
+	r.done = last
+	return nil
+}
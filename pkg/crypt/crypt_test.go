@@ -0,0 +1,123 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"testing"
+)
+
+func encrypt(t *testing.T, key, plain []byte) []byte {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(key, data []byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(data), key)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func newKey(t *testing.T) []byte {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func random(t *testing.T, n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// 块大小的整数倍和边界附近的长度都要能还原
+var sizes = []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3 * ChunkSize}
+
+func TestRoundTrip(t *testing.T) {
+	key := newKey(t)
+	for _, n := range sizes {
+		plain := random(t, n)
+		data := encrypt(t, key, plain)
+		got, err := decrypt(key, data)
+		if err != nil {
+			t.Fatalf("%d 字节: %v", n, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Fatalf("%d 字节: 解密后内容不一致", n)
+		}
+		id, err := ReadKeyId(bytes.NewReader(data))
+		if err != nil || id != KeyId(key) {
+			t.Fatalf("%d 字节: 密钥编号 %s, %v", n, id, err)
+		}
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	key := newKey(t)
+	overhead := 16
+	for _, n := range sizes {
+		data := encrypt(t, key, random(t, n))
+		cuts := []int{headerSize, len(data) - 1}
+		// 在块的边界截断, 剩下的都是完整的块, 只能靠最后一块的标记发现
+		if n > ChunkSize {
+			cuts = append(cuts, headerSize+ChunkSize+overhead)
+		}
+		for _, cut := range cuts {
+			if cut >= len(data) {
+				continue
+			}
+			if _, err := decrypt(key, data[:cut]); err != ErrCorrupt {
+				t.Fatalf("%d 字节截断到 %d: 应返回ErrCorrupt, 实际 %v", n, cut, err)
+			}
+		}
+	}
+	if _, err := decrypt(key, encrypt(t, key, nil)[:headerSize-1]); err != ErrFormat {
+		t.Fatalf("文件头不完整: 应返回ErrFormat, 实际 %v", err)
+	}
+}
+
+func TestTampered(t *testing.T) {
+	key := newKey(t)
+	data := encrypt(t, key, random(t, 2*ChunkSize+100))
+	// 密文、tag和文件头中的nonce前缀、块大小都参与校验
+	for _, i := range []int{headerSize, headerSize + ChunkSize, len(data) - 1, 20, 19} {
+		tampered := append([]byte(nil), data...)
+		tampered[i] ^= 1
+		if _, err := decrypt(key, tampered); err != ErrCorrupt && err != ErrFormat {
+			t.Fatalf("修改第 %d 字节: 应无法解密, 实际 %v", i, err)
+		}
+	}
+	// 调换两块
+	swapped := append([]byte(nil), data...)
+	a := swapped[headerSize : headerSize+ChunkSize+16]
+	b := append([]byte(nil), swapped[headerSize+ChunkSize+16:headerSize+2*(ChunkSize+16)]...)
+	copy(swapped[headerSize+ChunkSize+16:], a)
+	copy(swapped[headerSize:], b)
+	if _, err := decrypt(key, swapped); err != ErrCorrupt {
+		t.Fatalf("调换块: 应返回ErrCorrupt, 实际 %v", err)
+	}
+	bad := append([]byte(nil), data...)
+	bad[0] ^= 1
+	if _, err := decrypt(key, bad); err != ErrFormat {
+		t.Fatalf("修改magic: 应返回ErrFormat, 实际 %v", err)
+	}
+	if _, err := decrypt(newKey(t), data); err != ErrKey {
+		t.Fatalf("其他密钥: 应返回ErrKey, 实际 %v", err)
+	}
+}
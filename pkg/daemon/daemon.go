@@ -0,0 +1,24 @@
+package daemon
+
+import (
+	"os"
+	"os/exec"
+)
+
+// 以后台进程重新启动自身, 返回子进程的pid, 兼容原来的-d true启动方式
+// 子进程脱离当前会话, 标准输入输出重定向到空设备, 日志只写入配置的输出
+func Start(args []string) (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	cmd := exec.Command(exe, args...)
+	cmd.Env = os.Environ()
+	cmd.SysProcAttr = sysProcAttr()
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	pid := cmd.Process.Pid
+	cmd.Process.Release()
+	return pid, nil
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package daemon
+
+import "syscall"
+
+func sysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
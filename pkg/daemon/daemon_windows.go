@@ -0,0 +1,11 @@
+package daemon
+
+import "syscall"
+
+const (
+	detachedProcess = 0x00000008
+)
+
+func sysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: detachedProcess | syscall.CREATE_NEW_PROCESS_GROUP}
+}
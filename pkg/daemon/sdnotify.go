@@ -0,0 +1,75 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sd_notify状态, 见systemd的sd_notify(3)
+const (
+	StateReady     = "READY=1"
+	StateReloading = "RELOADING=1"
+	StateStopping  = "STOPPING=1"
+	StateWatchdog  = "WATCHDOG=1"
+)
+
+// 向systemd发送状态, 不是由systemd以Type=notify启动时返回false
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// @开头的是抽象命名空间的socket
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// 附带说明的状态, systemctl status中显示
+func Status(format string, a ...interface{}) string {
+	return "STATUS=" + fmt.Sprintf(format, a...)
+}
+
+// systemd要求的看门狗间隔, 没有配置WatchdogSec或不是发给本进程时返回0
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// 按看门狗间隔的一半发送WATCHDOG=1, healthy返回false时停止发送, 由systemd重启进程
+func Watchdog(healthy func() bool, stop <-chan struct{}) {
+	interval := WatchdogInterval()
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if healthy == nil || healthy() {
+				Notify(StateWatchdog)
+			}
+		}
+	}
+}
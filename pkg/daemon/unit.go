@@ -0,0 +1,52 @@
+package daemon
+
+import (
+	"io"
+	"text/template"
+)
+
+// 生成systemd unit文件所需的参数
+type UnitOptions struct {
+	Exec     string
+	Path     string
+	Type     string
+	User     string
+	Group    string
+	Watchdog int
+}
+
+var unitTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description=dcm-timer DICOM exam copy daemon
+After=network-online.target remote-fs.target
+Wants=network-online.target
+
+[Service]
+Type=notify
+NotifyAccess=main
+Environment=DCM_TIMER_PATH={{.Path}}
+{{- if .Type}}
+Environment=DCM_TIMER_TYPE={{.Type}}
+{{- end}}
+ExecStart={{.Exec}}
+ExecReload=/bin/kill -HUP $MAINPID
+KillSignal=SIGTERM
+TimeoutStopSec=30
+Restart=on-failure
+RestartSec=5
+{{- if gt .Watchdog 0}}
+WatchdogSec={{.Watchdog}}
+{{- end}}
+{{- if .User}}
+User={{.User}}
+{{- end}}
+{{- if .Group}}
+Group={{.Group}}
+{{- end}}
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+func WriteUnit(w io.Writer, opts UnitOptions) error {
+	return unitTemplate.Execute(w, opts)
+}
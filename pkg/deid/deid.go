@@ -0,0 +1,235 @@
+package deid
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// 对标识字段的处理方式
+const (
+	ActionKeep      = "keep"
+	ActionRemove    = "remove"
+	ActionBlank     = "blank"
+	ActionReplace   = "replace"
+	ActionPseudonym = "pseudonym"
+)
+
+// 按元素名或属性名匹配, 不区分大小写
+type Rule struct {
+	Element string
+	Action  string
+	// replace时的替换值
+	Value string
+}
+
+// 短于此长度的真实值不在其他文本中替换, 避免误改无关内容, 如性别M
+const minKnownLen = 3
+
+type Profile struct {
+	rules map[string]Rule
+	// 同一元素的同一真实值总是得到同一假名, 对照表中的所有真实值在其他文本和属性中也替换为假名
+	table *Pseudonyms
+}
+
+func NewProfile(rules []Rule, table *Pseudonyms) (*Profile, error) {
+	p := &Profile{rules: make(map[string]Rule), table: table}
+	for _, r := range rules {
+		r.Action = strings.ToLower(r.Action)
+		switch r.Action {
+		case ActionKeep, ActionRemove, ActionBlank, ActionReplace, ActionPseudonym:
+		default:
+			return nil, fmt.Errorf("非法的去标识处理方式 %s: %s", r.Element, r.Action)
+		}
+		p.rules[strings.ToLower(r.Element)] = r
+	}
+	return p, nil
+}
+
+func (p *Profile) rule(name string) (Rule, bool) {
+	r, ok := p.rules[strings.ToLower(name)]
+	if !ok || r.Action == ActionKeep {
+		return r, false
+	}
+	return r, true
+}
+
+func (p *Profile) apply(r Rule, value string) (string, error) {
+	switch r.Action {
+	case ActionBlank:
+		return "", nil
+	case ActionReplace:
+		return r.Value, nil
+	case ActionPseudonym:
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return "", nil
+		}
+		return p.table.Get(r.Element, value)
+	}
+	return value, nil
+}
+
+// 对照表中的真实值 => 假名, 长的优先, 一个值包含另一个值时先替换完整的
+func (p *Profile) replacer() *strings.Replacer {
+	known := p.table.Values()
+	values := make([]string, 0, len(known))
+	for v := range known {
+		if len(v) >= minKnownLen {
+			values = append(values, v)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if len(values[i]) != len(values[j]) {
+			return len(values[i]) > len(values[j])
+		}
+		return values[i] < values[j]
+	})
+	pairs := make([]string, 0, len(values)*2)
+	for _, v := range values {
+		pairs = append(pairs, v, known[v])
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// 按规则改写标识字段, 其他文本和属性中出现的已知真实值也替换为假名; 注释可能包含标识信息, 一律丢弃
+// 先完整读一遍登记文档中所有需要假名的值, 出现在对应元素之前的也能替换
+func (p *Profile) Rewrite(r io.Reader, w io.Writer) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if err := p.rewrite(data, ioutil.Discard, strings.NewReplacer()); err != nil {
+		return err
+	}
+	return p.rewrite(data, w, p.replacer())
+}
+
+// 逐个读取原始的token改写, 不解析命名空间, 元素和属性保留原有的前缀和xmlns声明
+func (p *Profile) rewrite(data []byte, w io.Writer, known *strings.Replacer) error {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	out := bufio.NewWriter(w)
+	// 被删除元素的嵌套深度, 大于0时丢弃所有内容
+	removing := 0
+	// 正在改写的元素, 其文本先缓存, 遇到子元素或结束时写出
+	var current *Rule
+	var text strings.Builder
+	flush := func() error {
+		if current == nil {
+			return nil
+		}
+		value, err := p.apply(*current, text.String())
+		if err != nil {
+			return err
+		}
+		current = nil
+		text.Reset()
+		textEscaper.WriteString(out, value)
+		return nil
+	}
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if removing > 0 {
+				removing++
+				continue
+			}
+			if err := flush(); err != nil {
+				return err
+			}
+			if rule, ok := p.rule(t.Name.Local); ok {
+				if rule.Action == ActionRemove {
+					removing = 1
+					continue
+				}
+				current = &rule
+			}
+			out.WriteString("<" + qualified(t.Name))
+			for _, a := range t.Attr {
+				value, keep, err := p.rewriteAttr(a, known)
+				if err != nil {
+					return err
+				}
+				if keep {
+					out.WriteString(" " + qualified(a.Name) + `="`)
+					attrEscaper.WriteString(out, value)
+					out.WriteString(`"`)
+				}
+			}
+			out.WriteString(">")
+		case xml.EndElement:
+			if removing > 0 {
+				removing--
+				continue
+			}
+			if err := flush(); err != nil {
+				return err
+			}
+			out.WriteString("</" + qualified(t.Name) + ">")
+		case xml.CharData:
+			if removing > 0 {
+				continue
+			}
+			if current != nil {
+				text.Write(t)
+				continue
+			}
+			textEscaper.WriteString(out, known.Replace(string(t)))
+		case xml.Comment:
+		case xml.ProcInst:
+			if removing > 0 {
+				continue
+			}
+			out.WriteString("<?" + t.Target)
+			if len(t.Inst) > 0 {
+				out.WriteString(" " + string(t.Inst))
+			}
+			out.WriteString("?>")
+		case xml.Directive:
+			if removing > 0 {
+				continue
+			}
+			out.WriteString("<!" + string(t) + ">")
+		}
+	}
+	return out.Flush()
+}
+
+// 返回改写后的属性值, 按规则删除时keep为false
+func (p *Profile) rewriteAttr(a xml.Attr, known *strings.Replacer) (string, bool, error) {
+	rule, ok := p.rule(a.Name.Local)
+	if !ok {
+		return known.Replace(a.Value), true, nil
+	}
+	if rule.Action == ActionRemove {
+		return "", false, nil
+	}
+	value, err := p.apply(rule, a.Value)
+	return value, true, err
+}
+
+// RawToken返回的Space是原文中的前缀
+func qualified(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;",
+		"\n", "&#xA;", "\r", "&#xD;", "\t", "&#x9;")
+)
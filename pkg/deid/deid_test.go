@@ -0,0 +1,160 @@
+package deid
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var rules = []Rule{
+	{Element: "PatientName", Action: ActionPseudonym},
+	{Element: "patientid", Action: ActionPseudonym},
+	{Element: "OtherPatientIDs", Action: ActionRemove},
+	{Element: "PatientBirthDate", Action: ActionBlank},
+	{Element: "InstitutionName", Action: ActionReplace, Value: "ANON"},
+	{Element: "Priority", Action: ActionKeep},
+	{Element: "Sex", Action: ActionPseudonym},
+}
+
+func newProfile(t *testing.T) (*Profile, *Pseudonyms) {
+	table, err := LoadPseudonyms(filepath.Join(t.TempDir(), "pseudonyms.json"), "ANON-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewProfile(rules, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, table
+}
+
+func rewrite(t *testing.T, p *Profile, in string) string {
+	var out strings.Builder
+	if err := p.Rewrite(strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func pseudonym(t *testing.T, table *Pseudonyms, element, value string) string {
+	v, err := table.Get(element, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestActions(t *testing.T) {
+	p, table := newProfile(t)
+	in := `<Exam PatientID="P-1" InstitutionName="First Hospital">` +
+		`<!-- Doe^John P-1 -->` +
+		`<patientname> Doe^John </patientname>` +
+		`<OtherPatientIDs><Item>X-9</Item></OtherPatientIDs>` +
+		`<PatientBirthDate>19700101</PatientBirthDate>` +
+		`<InstitutionName>First Hospital</InstitutionName>` +
+		`<Priority>STAT</Priority>` +
+		`</Exam>`
+	name, id := pseudonym(t, table, "PatientName", "Doe^John"), pseudonym(t, table, "PatientID", "P-1")
+	want := `<Exam PatientID="` + id + `" InstitutionName="ANON">` +
+		`<patientname>` + name + `</patientname>` +
+		`<PatientBirthDate></PatientBirthDate>` +
+		`<InstitutionName>ANON</InstitutionName>` +
+		`<Priority>STAT</Priority>` +
+		`</Exam>`
+	if got := rewrite(t, p, in); got != want {
+		t.Fatalf("改写结果\n%s\n期望\n%s", got, want)
+	}
+	if _, err := NewProfile([]Rule{{Element: "PatientName", Action: "hash"}}, table); err == nil {
+		t.Fatal("非法的处理方式应返回错误")
+	}
+}
+
+func TestPseudonymsStable(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "research", "pseudonyms.json")
+	table, err := LoadPseudonyms(p, "ANON-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := pseudonym(t, table, "PatientID", "P-1")
+	if !strings.HasPrefix(a, "ANON-") {
+		t.Fatalf("假名 %s 没有前缀", a)
+	}
+	if b := pseudonym(t, table, "patientid", "P-1"); b != a {
+		t.Fatalf("同一真实值得到不同假名: %s, %s", a, b)
+	}
+	if b := pseudonym(t, table, "PatientID", "P-2"); b == a {
+		t.Fatalf("不同真实值得到相同假名: %s", a)
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		t.Fatalf("对照表权限 %v, 其他用户可以读取", info.Mode().Perm())
+	}
+	// 重新加载后沿用已有的假名
+	loaded, err := LoadPseudonyms(p, "ANON-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := pseudonym(t, loaded, "PatientID", "P-1"); b != a {
+		t.Fatalf("重新加载后假名变化: %s, %s", a, b)
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	p, table := newProfile(t)
+	in := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<ns:Exam xmlns:ns="urn:exam" xmlns="urn:default" xmlns:x="urn:ext" x:ref="a&amp;b">` + "\n" +
+		`  <ns:PatientName>Doe^John</ns:PatientName>` + "\n" +
+		`  <Study x:uid="1.2.3"><x:Note>a &lt; b</x:Note></Study>` + "\n" +
+		`</ns:Exam>`
+	name := pseudonym(t, table, "PatientName", "Doe^John")
+	want := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<ns:Exam xmlns:ns="urn:exam" xmlns="urn:default" xmlns:x="urn:ext" x:ref="a&amp;b">` + "\n" +
+		`  <ns:PatientName>` + name + `</ns:PatientName>` + "\n" +
+		`  <Study x:uid="1.2.3"><x:Note>a &lt; b</x:Note></Study>` + "\n" +
+		`</ns:Exam>`
+	got := rewrite(t, p, in)
+	if got != want {
+		t.Fatalf("改写结果\n%s\n期望\n%s", got, want)
+	}
+	// 按命名空间解析的结果与原文一致
+	var exam struct {
+		XMLName xml.Name
+		Study   struct {
+			Uid  string `xml:"urn:ext uid,attr"`
+			Note string `xml:"urn:ext Note"`
+		} `xml:"urn:default Study"`
+	}
+	if err := xml.Unmarshal([]byte(got), &exam); err != nil {
+		t.Fatal(err)
+	}
+	if exam.XMLName.Space != "urn:exam" || exam.Study.Uid != "1.2.3" || exam.Study.Note != "a < b" {
+		t.Fatalf("命名空间解析结果不一致: %+v", exam)
+	}
+}
+
+func TestKnownValues(t *testing.T) {
+	p, table := newProfile(t)
+	// 导出前登记的检查号
+	anon := pseudonym(t, table, "exam", "s2026101912000000001")
+	in := `<Exam ref="s2026101912000000001" by="Doe^John">` +
+		`<Summary>Exam s2026101912000000001 of Doe^John</Summary>` +
+		`<PatientName>Doe^John</PatientName>` +
+		`<Sex>M</Sex><Note>M</Note>` +
+		`</Exam>`
+	name := pseudonym(t, table, "PatientName", "Doe^John")
+	sex := pseudonym(t, table, "Sex", "M")
+	// 对应元素之前出现的姓名也替换; 太短的值只在对应元素中替换
+	want := `<Exam ref="` + anon + `" by="` + name + `">` +
+		`<Summary>Exam ` + anon + ` of ` + name + `</Summary>` +
+		`<PatientName>` + name + `</PatientName>` +
+		`<Sex>` + sex + `</Sex><Note>M</Note>` +
+		`</Exam>`
+	if got := rewrite(t, p, in); got != want {
+		t.Fatalf("改写结果\n%s\n期望\n%s", got, want)
+	}
+}
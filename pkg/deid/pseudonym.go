@@ -0,0 +1,116 @@
+package deid
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 真实值与假名的对照表, 保存在本地, 文件本身包含标识信息, 只允许本用户读写
+type Pseudonyms struct {
+	lock   sync.Mutex
+	path   string
+	prefix string
+	// 元素名(小写) => 真实值 => 假名
+	table map[string]map[string]string
+}
+
+func LoadPseudonyms(p, prefix string) (*Pseudonyms, error) {
+	s := &Pseudonyms{path: p, prefix: prefix, table: make(map[string]map[string]string)}
+	data, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.table); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// 返回已有的假名, 没有时生成新的假名并立即保存
+func (s *Pseudonyms) Get(element, value string) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	element = strings.ToLower(element)
+	m, ok := s.table[element]
+	if !ok {
+		m = make(map[string]string)
+		s.table[element] = m
+	}
+	if pseudonym, ok := m[value]; ok {
+		return pseudonym, nil
+	}
+	pseudonym, err := s.generate(m)
+	if err != nil {
+		return "", err
+	}
+	m[value] = pseudonym
+	if err := s.save(); err != nil {
+		delete(m, value)
+		return "", err
+	}
+	return pseudonym, nil
+}
+
+// 所有元素的真实值 => 假名, 同一真实值在不同元素下有不同假名时取元素名排序最前的
+func (s *Pseudonyms) Values() map[string]string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	elements := make([]string, 0, len(s.table))
+	for element := range s.table {
+		elements = append(elements, element)
+	}
+	sort.Strings(elements)
+	values := make(map[string]string)
+	for _, element := range elements {
+		for value, pseudonym := range s.table[element] {
+			if _, ok := values[value]; !ok {
+				values[value] = pseudonym
+			}
+		}
+	}
+	return values
+}
+
+// 随机生成, 假名与真实值没有可推算的关系
+func (s *Pseudonyms) generate(m map[string]string) (string, error) {
+	used := make(map[string]bool, len(m))
+	for _, v := range m {
+		used[v] = true
+	}
+	for {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		pseudonym := s.prefix + strings.ToUpper(hex.EncodeToString(b))
+		if !used[pseudonym] {
+			return pseudonym, nil
+		}
+	}
+}
+
+// 先写临时文件再改名, 避免写入中断损坏对照表
+func (s *Pseudonyms) save() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s.table, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,41 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// 执行外部命令, 检查信息通过环境变量和标准输入的JSON传入, 退出码非0视为失败
+type CommandHook struct {
+	name string
+	Path string
+	Args []string
+}
+
+func (h *CommandHook) Name() string {
+	return h.name
+}
+
+func (h *CommandHook) Run(ctx context.Context, e Exam, data []byte) error {
+	cmd := exec.CommandContext(ctx, h.Path, h.Args...)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("DCM_EXAM=%s", e.Exam),
+		fmt.Sprintf("DCM_SRC=%s", e.Src),
+		fmt.Sprintf("DCM_DST=%s", e.Dst),
+		fmt.Sprintf("DCM_FILES=%d", len(e.Files)),
+		fmt.Sprintf("DCM_MANIFEST=%s", e.Manifest),
+		fmt.Sprintf("DCM_HOST=%s", e.Host),
+		fmt.Sprintf("DCM_HOOK=%s", h.name),
+	)
+	cmd.Stdin = bytes.NewReader(data)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return fmt.Errorf("命令 %s 执行失败: %v, 输出: %s", h.Path, err, out)
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package hook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/sanguohot/dcm-timer/etc"
+	"github.com/sanguohot/dcm-timer/pkg/audit"
+	"github.com/sanguohot/dcm-timer/pkg/common/file"
+	"github.com/sanguohot/dcm-timer/pkg/common/log"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// 拷贝后处理钩子的类型
+const (
+	TypeCommand = "command"
+	TypeWebhook = "webhook"
+	TypeSpool   = "spool"
+)
+
+var (
+	host, _  = os.Hostname()
+	pending  sync.WaitGroup
+	hooksDir = "hooks"
+	// 钩子使用独立的ctx, 退出时拷贝任务先取消, 钩子由Wait等待到超时后再取消
+	hookCtx, cancelHooks = context.WithCancel(context.Background())
+	// Wait超时取消后, 等待钩子记录取消结果的时间
+	cancelGrace = 2 * time.Second
+	// 正在执行钩子的检查, 同一检查不重复执行
+	runningLock sync.Mutex
+	running     = make(map[string]bool)
+)
+
+type File struct {
+	Src  string `json:"src"`
+	Dst  string `json:"dst"`
+	Size int64  `json:"size"`
+}
+
+// 传给钩子的检查信息
+type Exam struct {
+	Exam     string    `json:"exam"`
+	Src      string    `json:"src"`
+	Dst      string    `json:"dst"`
+	Files    []File    `json:"files"`
+	Manifest string    `json:"manifest"`
+	CopiedAt time.Time `json:"copied_at"`
+	Host     string    `json:"host"`
+}
+
+// 一个钩子的执行结果
+type Result struct {
+	Hook     string    `json:"hook"`
+	Type     string    `json:"type"`
+	Ok       bool      `json:"ok"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error,omitempty"`
+	Started  time.Time `json:"started"`
+	Elapsed  float64   `json:"elapsed"`
+}
+
+// 一个检查最近一次拷贝后各钩子的执行结果
+type Record struct {
+	Exam     string    `json:"exam"`
+	Dst      string    `json:"dst"`
+	CopiedAt time.Time `json:"copied_at"`
+	Results  []Result  `json:"results"`
+}
+
+type Hook interface {
+	Name() string
+	Run(ctx context.Context, e Exam, data []byte) error
+}
+
+type config struct {
+	hook       Hook
+	typ        string
+	timeout    time.Duration
+	retries    int
+	retryDelay time.Duration
+}
+
+// 按配置生成钩子, 配置错误的钩子跳过并记录日志
+func getHooks() []config {
+	var hooks []config
+	for i, h := range etc.Get().Hooks {
+		name := h.Name
+		if name == "" {
+			name = fmt.Sprintf("%s-%d", h.Type, i+1)
+		}
+		c := config{typ: h.Type, timeout: time.Duration(h.Timeout) * time.Second, retries: h.Retries,
+			retryDelay: time.Duration(h.RetryDelay) * time.Second}
+		if c.timeout <= 0 {
+			c.timeout = 60 * time.Second
+		}
+		switch h.Type {
+		case TypeCommand:
+			c.hook = &CommandHook{name: name, Path: h.Path, Args: h.Args}
+		case TypeWebhook:
+			c.hook = &WebhookHook{name: name, Url: h.Url}
+		case TypeSpool:
+			c.hook = &SpoolHook{name: name, Dir: h.Dir}
+		default:
+			log.Error("hook.invalid", zap.String("hook", name), zap.String("type", h.Type))
+			continue
+		}
+		hooks = append(hooks, c)
+	}
+	return hooks
+}
+
+// 异步按配置顺序执行钩子, 结果写入状态目录和审计日志, 不影响拷贝结果
+func Trigger(e Exam) {
+	hooks := getHooks()
+	if len(hooks) == 0 {
+		return
+	}
+	e.Host = host
+	runningLock.Lock()
+	if running[e.Exam] {
+		runningLock.Unlock()
+		return
+	}
+	running[e.Exam] = true
+	runningLock.Unlock()
+	pending.Add(1)
+	go func() {
+		defer pending.Done()
+		defer func() {
+			runningLock.Lock()
+			delete(running, e.Exam)
+			runningLock.Unlock()
+		}()
+		record := Record{Exam: e.Exam, Dst: e.Dst, CopiedAt: e.CopiedAt}
+		for _, h := range hooks {
+			record.Results = append(record.Results, run(hookCtx, h, e))
+		}
+		if err := saveRecord(record); err != nil {
+			log.Error("hook.save_failed", log.Exam(e.Exam), zap.Error(err))
+		}
+	}()
+}
+
+func run(ctx context.Context, h config, e Exam) Result {
+	r := Result{Hook: h.hook.Name(), Type: h.typ, Started: time.Now()}
+	data, err := json.Marshal(e)
+	if err == nil {
+		for {
+			r.Attempts++
+			if err = runOnce(ctx, h, e, data); err == nil || r.Attempts > h.retries || ctx.Err() != nil {
+				break
+			}
+			log.Warn("hook.retry", zap.String("hook", r.Hook), log.Exam(e.Exam), zap.Int("retry", r.Attempts), zap.Error(err))
+			select {
+			case <-ctx.Done():
+			case <-time.After(h.retryDelay):
+			}
+		}
+	}
+	r.Elapsed = time.Since(r.Started).Seconds()
+	if err != nil {
+		r.Error = err.Error()
+		log.Error("hook.failed", zap.String("hook", r.Hook), log.Exam(e.Exam), zap.Int("attempts", r.Attempts), zap.Error(err))
+		audit.Append(audit.Record{Action: audit.ActionHook, Exam: e.Exam, Dst: e.Dst, Outcome: audit.OutcomeFailed,
+			Detail: fmt.Sprintf("%s: %s", r.Hook, r.Error)})
+		return r
+	}
+	r.Ok = true
+	log.Info("hook.success", zap.String("hook", r.Hook), log.Exam(e.Exam), zap.Int("attempts", r.Attempts), zap.Float64("elapsed", r.Elapsed))
+	audit.Append(audit.Record{Action: audit.ActionHook, Exam: e.Exam, Dst: e.Dst, Detail: r.Hook})
+	return r
+}
+
+func runOnce(ctx context.Context, h config, e Exam, data []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	return h.hook.Run(ctx, e, data)
+}
+
+func getRecordPath(exam string) string {
+	return path.Join(etc.GetStatePath(), hooksDir, exam+".json")
+}
+
+func saveRecord(r Record) error {
+	if err := file.EnsureDir(path.Join(etc.GetStatePath(), hooksDir)); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(getRecordPath(r.Exam), data, 0644)
+}
+
+// 检查的钩子需要重新执行: 配置了钩子且没有正在执行, 没有执行记录或上一次有钩子失败(包括退出时被取消)
+func Unsettled(exam string) bool {
+	if len(etc.Get().Hooks) == 0 {
+		return false
+	}
+	runningLock.Lock()
+	inFlight := running[exam]
+	runningLock.Unlock()
+	if inFlight {
+		return false
+	}
+	r, err := GetRecord(exam)
+	if err != nil {
+		return true
+	}
+	for _, result := range r.Results {
+		if !result.Ok {
+			return true
+		}
+	}
+	return false
+}
+
+// 检查最近一次拷贝后的钩子执行结果
+func GetRecord(exam string) (*Record, error) {
+	data, err := ioutil.ReadFile(getRecordPath(exam))
+	if err != nil {
+		return nil, err
+	}
+	var r Record
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// 等待正在执行的钩子结束, 用于进程退出前; 超过timeout时取消剩余的钩子, 取消结果写入执行记录
+func Wait(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-time.After(timeout):
+	}
+	log.Warn("hook.wait_timeout", zap.Duration("timeout", timeout))
+	cancelHooks()
+	select {
+	case <-done:
+	case <-time.After(cancelGrace):
+	}
+}
@@ -0,0 +1,42 @@
+package hook
+
+import (
+	"context"
+	"fmt"
+	"github.com/sanguohot/dcm-timer/etc"
+	"github.com/sanguohot/dcm-timer/pkg/common/file"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 在spool目录放入检查信息的JSON, 先写临时文件再改名, 下游不会读到不完整的文件
+type SpoolHook struct {
+	name string
+	Dir  string
+}
+
+func (h *SpoolHook) Name() string {
+	return h.name
+}
+
+func (h *SpoolHook) Run(ctx context.Context, e Exam, data []byte) error {
+	dir := h.Dir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(etc.GetServerDir(), dir)
+	}
+	if err := file.EnsureDir(dir); err != nil {
+		return err
+	}
+	name := filepath.Join(dir, fmt.Sprintf("%s-%d.json", e.Exam, time.Now().UnixNano()))
+	tmp := filepath.Join(dir, "."+filepath.Base(name)+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
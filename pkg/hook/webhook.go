@@ -0,0 +1,35 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// 以JSON格式POST检查信息, 返回4xx或5xx视为失败
+type WebhookHook struct {
+	name string
+	Url  string
+}
+
+func (h *WebhookHook) Name() string {
+	return h.name
+}
+
+func (h *WebhookHook) Run(ctx context.Context, e Exam, data []byte) error {
+	req, err := http.NewRequest(http.MethodPost, h.Url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("webhook %s 返回 %s", h.Url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// 执行外部命令, 事件通过环境变量和标准输入的JSON传入
+type CommandSink struct {
+	Path string
+	Args []string
+}
+
+func (s *CommandSink) Name() string {
+	return "command"
+}
+
+func (s *CommandSink) Send(e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), getTimeout())
+	defer cancel()
+	cmd := exec.CommandContext(ctx, s.Path, s.Args...)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("DCM_EVENT=%s", e.Name),
+		fmt.Sprintf("DCM_MESSAGE=%s", e.Message),
+		fmt.Sprintf("DCM_HOST=%s", e.Host),
+	)
+	cmd.Stdin = bytes.NewReader(data)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("命令 %s 执行失败: %v, 输出: %s", s.Path, err, out)
+	}
+	return nil
+}
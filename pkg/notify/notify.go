@@ -0,0 +1,115 @@
+package notify
+
+import (
+	"github.com/sanguohot/dcm-timer/etc"
+	"github.com/sanguohot/dcm-timer/pkg/common/log"
+	"go.uber.org/zap"
+	"os"
+	"sync"
+	"time"
+)
+
+// 可以订阅的事件
+const (
+	EventCopyFailed     = "copy_failed"
+	EventDeadLetter     = "dead_letter"
+	EventDiskLow        = "disk_low"
+	EventCleanerDeleted = "cleaner_deleted"
+	EventScanOverrun    = "scan_overrun"
+	EventDaemonStart    = "daemon_start"
+	EventDaemonStop     = "daemon_stop"
+)
+
+type Event struct {
+	Name    string                 `json:"event"`
+	Time    time.Time              `json:"time"`
+	Host    string                 `json:"host"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// 通知渠道
+type Sink interface {
+	Name() string
+	Send(e Event) error
+}
+
+var (
+	host, _ = os.Hostname()
+	pending sync.WaitGroup
+)
+
+// 按配置生成通知渠道, 未配置的渠道不启用
+func getSinks() []Sink {
+	var sinks []Sink
+	cfg := etc.Get().Notify
+	if cfg.Webhook.Url != "" {
+		sinks = append(sinks, &WebhookSink{Url: cfg.Webhook.Url})
+	}
+	if cfg.Smtp.Address != "" && len(cfg.Smtp.To) > 0 {
+		sinks = append(sinks, &SmtpSink{})
+	}
+	if cfg.Syslog.Enabled {
+		sinks = append(sinks, &SyslogSink{})
+	}
+	if cfg.Command.Path != "" {
+		sinks = append(sinks, &CommandSink{Path: cfg.Command.Path, Args: cfg.Command.Args})
+	}
+	return sinks
+}
+
+func getTimeout() time.Duration {
+	if etc.Get().Notify.Timeout <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(etc.Get().Notify.Timeout) * time.Second
+}
+
+// 事件是否订阅, 没有配置事件列表时全部订阅
+func subscribed(name string) bool {
+	if len(etc.Get().Notify.Events) == 0 {
+		return true
+	}
+	for _, event := range etc.Get().Notify.Events {
+		if event == name {
+			return true
+		}
+	}
+	return false
+}
+
+// 异步发送通知, 发送失败只记录日志
+func Send(name, message string, fields map[string]interface{}) {
+	if !subscribed(name) {
+		return
+	}
+	sinks := getSinks()
+	if len(sinks) == 0 {
+		return
+	}
+	e := Event{Name: name, Time: time.Now(), Host: host, Message: message, Fields: fields}
+	for _, sink := range sinks {
+		pending.Add(1)
+		go func(sink Sink) {
+			defer pending.Done()
+			if err := sink.Send(e); err != nil {
+				log.Error("notify.failed", zap.String("sink", sink.Name()), zap.String("notify_event", e.Name), zap.Error(err))
+				return
+			}
+			log.Debug("notify.sent", zap.String("sink", sink.Name()), zap.String("notify_event", e.Name))
+		}(sink)
+	}
+}
+
+// 等待已发出的通知发送完毕, 用于进程退出前
+func Wait(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
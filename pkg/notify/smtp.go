@@ -0,0 +1,90 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"github.com/sanguohot/dcm-timer/etc"
+	"net"
+	"net/smtp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 邮件通知, 服务器支持时自动使用STARTTLS
+type SmtpSink struct{}
+
+func (s *SmtpSink) Name() string {
+	return "smtp"
+}
+
+func (s *SmtpSink) Send(e Event) error {
+	cfg := etc.Get().Notify.Smtp
+	host, _, err := net.SplitHostPort(cfg.Address)
+	if err != nil {
+		return err
+	}
+	// smtp.SendMail没有超时, 服务器无响应时会一直阻塞通知队列
+	timeout := getTimeout()
+	conn, err := net.DialTimeout("tcp", cfg.Address, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(cfg.From); err != nil {
+		return err
+	}
+	for _, to := range cfg.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(formatMail(cfg.From, cfg.To, e)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func formatMail(from string, to []string, e Event) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: [dcm-timer] %s %s\r\n", e.Host, e.Name)
+	fmt.Fprintf(&buf, "Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	fmt.Fprintf(&buf, "%s\r\n\r\n时间: %s\r\n主机: %s\r\n", e.Message, e.Time.Format("2006-01-02 15:04:05"), e.Host)
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&buf, "%s: %v\r\n", k, e.Fields[k])
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,27 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package notify
+
+import (
+	"fmt"
+	"github.com/sanguohot/dcm-timer/etc"
+	"log/syslog"
+)
+
+// 发送到本机或远程syslog, network和address为空时使用本机
+type SyslogSink struct{}
+
+func (s *SyslogSink) Name() string {
+	return "syslog"
+}
+
+func (s *SyslogSink) Send(e Event) error {
+	cfg := etc.Get().Notify.Syslog
+	w, err := syslog.Dial(cfg.Network, cfg.Address, syslog.LOG_WARNING|syslog.LOG_DAEMON, cfg.Tag)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	return w.Warning(fmt.Sprintf("event=%s host=%s %s %v", e.Name, e.Host, e.Message, e.Fields))
+}
@@ -0,0 +1,13 @@
+package notify
+
+import "errors"
+
+type SyslogSink struct{}
+
+func (s *SyslogSink) Name() string {
+	return "syslog"
+}
+
+func (s *SyslogSink) Send(e Event) error {
+	return errors.New("windows不支持syslog")
+}
@@ -0,0 +1,34 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// 以JSON格式POST事件
+type WebhookSink struct {
+	Url string
+}
+
+func (s *WebhookSink) Name() string {
+	return "webhook"
+}
+
+func (s *WebhookSink) Send(e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: getTimeout()}
+	resp, err := client.Post(s.Url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("webhook %s 返回 %s", s.Url, resp.Status)
+	}
+	return nil
+}
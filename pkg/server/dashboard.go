@@ -0,0 +1,133 @@
+package server
+
+import (
+	_ "embed"
+	"github.com/sanguohot/dcm-timer/pkg/core"
+	"github.com/sanguohot/dcm-timer/pkg/hook"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	//go:embed dashboard.html
+	dashboardHtml []byte
+)
+
+// 看板页面, 不依赖外部资源, 通过/status和/exams轮询数据
+// http://localhost:9000/
+func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(dashboardHtml)
+}
+
+type status struct {
+	Time       time.Time             `json:"time"`
+	Pause      core.PauseState       `json:"pause"`
+	Queued     int                   `json:"queued"`
+	Running    int                   `json:"running"`
+	Workers    []core.WorkerActivity `json:"workers"`
+	Throughput []core.Throughput     `json:"throughput"`
+	Disk       core.DiskUsage        `json:"disk"`
+	DiskError  string                `json:"disk_error,omitempty"`
+	Tasks      []core.TaskStatus     `json:"tasks"`
+	Counts     map[string]int        `json:"counts"`
+}
+
+// 拷贝者、吞吐量、磁盘和定时任务的概况
+// curl http://localhost:9000/status
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "只支持GET")
+		return
+	}
+	s := status{Time: time.Now(), Pause: core.GetPauseState(), Workers: core.GetWorkers(), Throughput: core.GetThroughput()}
+	s.Queued, s.Running = core.GetQueueLen()
+	disk, err := core.GetDiskUsage()
+	if err != nil {
+		s.DiskError = err.Error()
+	}
+	s.Disk = disk
+	for _, task := range []string{core.TaskScan, core.TaskClean, core.TaskVerify} {
+		s.Tasks = append(s.Tasks, core.GetTaskStatus(task))
+	}
+	s.Counts = make(map[string]int)
+	for _, e := range core.GetExams("") {
+		s.Counts[e.Status]++
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+// 最近的检查, 可用status过滤
+// curl http://localhost:9000/exams?status=failed
+func examsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "只支持GET")
+		return
+	}
+	writeJSON(w, http.StatusOK, core.GetExams(r.URL.Query().Get("status")))
+}
+
+// 重试失败或被放弃的检查
+// curl -X POST http://localhost:9000/exams/retry?exam=s2018102922221914708
+func retryExamHandler(w http.ResponseWriter, r *http.Request) {
+	examAction(w, r, core.RetryExam)
+}
+
+// 重新拷贝已拷贝的检查
+// curl -X POST http://localhost:9000/exams/recopy?exam=s2018102922221914708
+// 配置了令牌时与暂停接口一样需要带 -H "Authorization: Bearer <token>"
+func recopyExamHandler(w http.ResponseWriter, r *http.Request) {
+	examAction(w, r, core.RecopyExam)
+}
+
+// 检查最近一次拷贝后各钩子的执行结果
+// curl http://localhost:9000/exams/hooks?exam=s2018102922221914708
+func examHooksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "只支持GET")
+		return
+	}
+	exam := r.FormValue("exam")
+	if exam == "" {
+		writeError(w, http.StatusBadRequest, "缺少参数exam")
+		return
+	}
+	record, err := hook.GetRecord(filepath.Base(exam))
+	if os.IsNotExist(err) {
+		writeError(w, http.StatusNotFound, "没有钩子执行记录")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, record)
+}
+
+func examAction(w http.ResponseWriter, r *http.Request, action func(string) (core.ExamActivity, error)) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "只支持POST")
+		return
+	}
+	exam := r.FormValue("exam")
+	if exam == "" {
+		writeError(w, http.StatusBadRequest, "缺少参数exam")
+		return
+	}
+	e, err := action(exam)
+	switch {
+	case err == core.ErrExamNotFound || os.IsNotExist(err):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err == core.ErrExamQueued:
+		writeError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		writeJSON(w, http.StatusAccepted, e)
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sanguohot/dcm-timer/pkg/stream"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// 没有事件时定期发送注释, 避免代理断开空闲连接
+	keepAliveInterval = 15 * time.Second
+)
+
+// 以server-sent events推送检查和拷贝事件, 断线重连时浏览器自动带上Last-Event-ID续传
+// 可用types和exam过滤, 如 curl -N 'http://localhost:9000/events?types=copy.completed,exam.deleted'
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "只支持GET")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "不支持流式响应")
+		return
+	}
+	lastId := r.Header.Get("Last-Event-ID")
+	if lastId == "" {
+		lastId = r.URL.Query().Get("last_event_id")
+	}
+	types := make(map[string]bool)
+	for _, t := range strings.Split(r.URL.Query().Get("types"), ",") {
+		if t != "" {
+			types[t] = true
+		}
+	}
+	exam := r.URL.Query().Get("exam")
+	match := func(e stream.Event) bool {
+		return (len(types) == 0 || types[e.Type]) && (exam == "" || e.Exam == exam)
+	}
+	backlog, ch, reset := stream.Subscribe(lastId)
+	defer stream.Unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: 3000\n\n")
+	if reset {
+		// reset不带id, 不会改变客户端的游标
+		writeEvent(w, stream.Event{Type: stream.EventReset, Time: time.Now(), Data: map[string]interface{}{"last_event_id": lastId}})
+	}
+	for _, e := range backlog {
+		if match(e) {
+			writeEvent(w, e)
+		}
+	}
+	flusher.Flush()
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprintf(w, ": keep-alive\n\n")
+		case e, ok := <-ch:
+			// 消费太慢被断开, 客户端带上游标重连后续传
+			if !ok {
+				return
+			}
+			if !match(e) {
+				continue
+			}
+			writeEvent(w, e)
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e stream.Event) {
+	data, _ := json.Marshal(e)
+	if e.Id != "" {
+		fmt.Fprintf(w, "id: %s\n", e.Id)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+}
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"github.com/sanguohot/dcm-timer/pkg/core"
+	"net/http"
+)
+
+// 存活检查, 定时任务循环没有心跳时返回503, 供编排系统重启进程
+// curl http://localhost:9000/healthz
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, core.CheckHealth(false))
+}
+
+// 就绪检查, 另外检查定时任务是否停滞、源目录、目标目录、磁盘空间、拷贝者和日志文件
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, core.CheckHealth(true))
+}
+
+func writeHealth(w http.ResponseWriter, report core.HealthReport) {
+	code := http.StatusOK
+	if !report.OK {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, report)
+}
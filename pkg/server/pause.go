@@ -0,0 +1,22 @@
+package server
+
+import (
+	"github.com/sanguohot/dcm-timer/pkg/core"
+	"net/http"
+)
+
+// GET查看暂停状态, PUT手动暂停, DELETE取消手动暂停
+// curl -X PUT http://localhost:9000/pause
+// 配置了令牌时 curl -X PUT -H "Authorization: Bearer <token>" http://localhost:9000/pause
+func pauseHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, core.GetPauseState())
+	case http.MethodPut:
+		writeJSON(w, http.StatusOK, core.SetPaused(true))
+	case http.MethodDelete:
+		writeJSON(w, http.StatusOK, core.SetPaused(false))
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "只支持GET、PUT和DELETE")
+	}
+}
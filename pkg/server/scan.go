@@ -0,0 +1,16 @@
+package server
+
+import (
+	"github.com/sanguohot/dcm-timer/pkg/core"
+	"net/http"
+)
+
+// 取消正在进行的检索和拷贝, 已开始拷贝的检查中止, 下一次检索照常进行
+// curl -X POST http://localhost:9000/scan/cancel
+func cancelScanHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "只支持POST")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"cancelled": core.CancelScan()})
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/sanguohot/dcm-timer/etc"
+	"github.com/sanguohot/dcm-timer/pkg/common/log"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+)
+
+// 管理接口, 与日志级别接口共用etc.Get().Log.Host, 端口为0时不启动
+func Start() {
+	if etc.GetLogHostPort() <= 0 {
+		return
+	}
+	if etc.Get().Log.Host.Token == "" && !isLoopback(etc.GetLogHostAddress()) {
+		log.Warn("server.no_token", zap.String("address", etc.GetLogHostAddress()))
+	}
+	http.HandleFunc("/level", authorized(log.Atom.ServeHTTP))
+	http.HandleFunc("/pause", authorized(pauseHandler))
+	http.HandleFunc("/scan/cancel", authorized(cancelScanHandler))
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/readyz", readyzHandler)
+	http.HandleFunc("/", dashboardHandler)
+	http.HandleFunc("/status", statusHandler)
+	http.HandleFunc("/exams", examsHandler)
+	http.HandleFunc("/exams/retry", authorized(retryExamHandler))
+	http.HandleFunc("/exams/recopy", authorized(recopyExamHandler))
+	http.HandleFunc("/exams/hooks", examHooksHandler)
+	http.HandleFunc("/events", eventsHandler)
+	go log.InitLogServer()
+}
+
+// 修改状态的请求需要带 Authorization: Bearer <etc.Get().Log.Host.Token>, 没有配置令牌时只接受本机的请求
+func authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			h(w, r)
+			return
+		}
+		token := etc.Get().Log.Host.Token
+		if token == "" {
+			if !isLoopback(r.RemoteAddr) {
+				writeError(w, http.StatusForbidden, "没有配置令牌, 只接受本机的修改请求")
+				return
+			}
+		} else if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "缺少或错误的令牌")
+			return
+		}
+		h(w, r)
+	}
+}
+
+// address为host或host:port
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
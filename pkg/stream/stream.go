@@ -0,0 +1,149 @@
+package stream
+
+import (
+	"fmt"
+	"github.com/sanguohot/dcm-timer/etc"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 推送给下游的事件
+const (
+	EventExamDiscovered = "exam.discovered"
+	EventCopyStarted    = "copy.started"
+	EventCopyProgress   = "copy.progress"
+	EventCopyCompleted  = "copy.completed"
+	EventCopyVerified   = "copy.verified"
+	EventCopyFailed     = "copy.failed"
+	EventExamDeleted    = "exam.deleted"
+	// 客户端给出的游标已不在缓冲区中, 中间的事件已丢失
+	EventReset = "reset"
+)
+
+// Id为"启动时间-序号", 程序重启后旧的游标不再有效
+type Event struct {
+	Id   string                 `json:"id"`
+	Type string                 `json:"type"`
+	Time time.Time              `json:"time"`
+	Exam string                 `json:"exam,omitempty"`
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+var (
+	bus = &Bus{boot: time.Now().Unix(), subs: make(map[chan Event]bool)}
+	// 每个订阅者的发送缓冲, 写满时断开, 由客户端带上游标重连
+	subscriberBuffer = 256
+)
+
+// 内存中的事件总线, 保留最近etc.Get().Stream.Buffer个事件用于断线续传
+type Bus struct {
+	lock   sync.Mutex
+	boot   int64
+	seq    uint64
+	buffer []Event
+	subs   map[chan Event]bool
+}
+
+func getBufferSize() int {
+	if etc.Get().Stream.Buffer <= 0 {
+		return 1000
+	}
+	return etc.Get().Stream.Buffer
+}
+
+func (b *Bus) Publish(typ, exam string, data map[string]interface{}) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.seq++
+	e := Event{Id: fmt.Sprintf("%d-%d", b.boot, b.seq), Type: typ, Time: time.Now(), Exam: exam, Data: data}
+	b.buffer = append(b.buffer, e)
+	if size := getBufferSize(); len(b.buffer) > size {
+		b.buffer = append(b.buffer[:0:0], b.buffer[len(b.buffer)-size:]...)
+	}
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			// 消费太慢的订阅者断开, 避免拖慢拷贝
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// 订阅lastId之后的事件, lastId为空时只接收新事件
+// lastId不在缓冲区中时reset为true, backlog为缓冲区中的全部事件
+func (b *Bus) Subscribe(lastId string) ([]Event, chan Event, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	ch := make(chan Event, subscriberBuffer)
+	b.subs[ch] = true
+	if lastId == "" {
+		return nil, ch, false
+	}
+	boot, seq, ok := parseId(lastId)
+	if !ok || boot != b.boot || seq > b.seq {
+		return append([]Event(nil), b.buffer...), ch, true
+	}
+	var backlog []Event
+	for _, e := range b.buffer {
+		if _, s, _ := parseId(e.Id); s > seq {
+			backlog = append(backlog, e)
+		}
+	}
+	// 缓冲区中最早的事件也晚于游标的下一个, 中间有事件被丢弃
+	reset := len(b.buffer) > 0 && firstSeq(b.buffer) > seq+1
+	return backlog, ch, reset
+}
+
+func (b *Bus) Unsubscribe(ch chan Event) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.subs[ch] {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+func firstSeq(events []Event) uint64 {
+	_, seq, _ := parseId(events[0].Id)
+	return seq
+}
+
+func parseId(id string) (int64, uint64, bool) {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	boot, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return boot, seq, true
+}
+
+func Publish(typ, exam string, data map[string]interface{}) {
+	bus.Publish(typ, exam, data)
+}
+
+func Subscribe(lastId string) ([]Event, chan Event, bool) {
+	return bus.Subscribe(lastId)
+}
+
+func Unsubscribe(ch chan Event) {
+	bus.Unsubscribe(ch)
+}
+
+// 拷贝进度事件的最小间隔, 文件拷贝完成时总会推送一次
+func GetProgressInterval() time.Duration {
+	if etc.Get().Stream.ProgressInterval <= 0 {
+		return time.Second
+	}
+	return time.Duration(etc.Get().Stream.ProgressInterval) * time.Millisecond
+}
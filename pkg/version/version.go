@@ -0,0 +1,4 @@
+package version
+
+// 编译时注入, 如 go build -ldflags "-X github.com/sanguohot/dcm-timer/pkg/version.Version=1.2.0"
+var Version = "dev"
@@ -2,4 +2,5 @@
 export DCM_TIMER_PATH=/opt/dcm-timer
 export DCM_TIMER_TYPE=production
 #nohup /opt/dcm-timer/dcm-timer > /opt/dcm-timer/nohup.out 2>&1 &
-/opt/dcm-timer/dcm-timer -d true
+# 由systemd管理时使用 dcm-timer systemd-unit 生成unit文件, 不需要本脚本
+/opt/dcm-timer/dcm-timer -d